@@ -0,0 +1,59 @@
+package tdigest
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/spenczar/tdigest/v2/internal/accuracy"
+)
+
+var accuracyQuantiles = []float64{0.001, 0.01, 0.1, 0.25, 0.5, 0.75, 0.9, 0.99, 0.999}
+
+// observedRankError holds the largest rank error seen at any of
+// accuracyQuantiles for each distribution, with the fixed seed and input
+// size used by TestAccuracy. The ordered input is reproduced exactly.
+var observedRankError = map[string]float64{
+	"ordered": 0,
+	"uniform": 0.00698,
+	"normal":  0.00679,
+	"zipfian": 0.02546,
+	"bimodal": 0.00553,
+	"pareto":  0.01526,
+}
+
+// rankErrorMargin is how far the rank error may grow past the observed error
+// before TestAccuracy fails: 50%, plus 0.0001 so that exact results may pick
+// up a little rounding.
+const (
+	rankErrorMargin = 1.5
+	rankErrorSlack  = 0.0001
+)
+
+// maxRankError returns the largest acceptable rank error for a distribution.
+// The bounds are meant to catch regressions, not to document the precision
+// of the algorithm.
+func maxRankError(dist string) float64 {
+	return observedRankError[dist]*rankErrorMargin + rankErrorSlack
+}
+
+func TestAccuracy(t *testing.T) {
+	rand.Seed(rngSeed)
+	for _, dist := range accuracy.Distributions {
+		dist := dist
+		t.Run(dist.Name, func(t *testing.T) {
+			if _, ok := observedRankError[dist.Name]; !ok {
+				t.Fatalf("no rank error bound for distribution %q", dist.Name)
+			}
+			vals := accuracy.Values(dist.New(rngSeed), 100000)
+			results := accuracy.Measure(New(), vals, accuracyQuantiles)
+			bound := maxRankError(dist.Name)
+			for _, r := range results {
+				t.Logf("q=%-6v exact=%-12.6g estimate=%-12.6g abs error=%-12.6g rank error=%.6f",
+					r.Q, r.Exact, r.Estimate, r.AbsError, r.RankError)
+				if r.RankError > bound {
+					t.Errorf("rank error too large at q=%v, have=%v, want<=%v", r.Q, r.RankError, bound)
+				}
+			}
+		})
+	}
+}
@@ -3,15 +3,25 @@ package tdigest
 import (
 	"math/rand"
 	"testing"
+
+	"github.com/spenczar/tdigest/v2/internal/accuracy"
 )
 
 const rngSeed = 1234567
 
-type valueSource interface {
-	Next() float64
+// newSource returns a source of values from the named distribution in
+// accuracy.Distributions, so that the benchmarks run on the same data that
+// the accuracy tests measure.
+func newSource(name string) accuracy.Source {
+	for _, dist := range accuracy.Distributions {
+		if dist.Name == name {
+			return dist.New(rngSeed)
+		}
+	}
+	panic("unknown distribution " + name)
 }
 
-func benchmarkAdd(b *testing.B, n int, src valueSource) {
+func benchmarkAdd(b *testing.B, n int, src accuracy.Source) {
 	valsToAdd := make([]float64, n)
 
 	d := NewWithCompression(100)
@@ -28,7 +38,7 @@ func benchmarkAdd(b *testing.B, n int, src valueSource) {
 	b.StopTimer()
 }
 
-func benchmarkQuantile(b *testing.B, n int, src valueSource) {
+func benchmarkQuantile(b *testing.B, n int, src accuracy.Source) {
 	quantilesToCheck := make([]float64, n)
 
 	d := NewWithCompression(100)
@@ -45,147 +55,180 @@ func benchmarkQuantile(b *testing.B, n int, src valueSource) {
 	b.StopTimer()
 }
 
-type orderedValues struct {
-	last float64
+// benchmarkQuantileImpl measures quantile, which implements
+// TDigest.Quantile, on a TDigest of n normally distributed values.
+func benchmarkQuantileImpl(b *testing.B, compression float64, n int, quantile func(*TDigest, float64) float64) {
+	src := newSource("normal")
+	d := NewWithCompression(compression)
+	for i := 0; i < n; i++ {
+		d.Add(src.Next(), 1)
+	}
+	qs := make([]float64, 1024)
+	for i := range qs {
+		qs[i] = rand.Float64()
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = quantile(d, qs[i%len(qs)])
+	}
+	b.StopTimer()
+}
+
+func BenchmarkQuantileSearch_100k_Compression100(b *testing.B) {
+	benchmarkQuantileImpl(b, 100, 100000, (*TDigest).Quantile)
 }
 
-func (ov *orderedValues) Next() float64 {
-	ov.last += 1
-	return ov.last
+func BenchmarkQuantileLinear_100k_Compression100(b *testing.B) {
+	benchmarkQuantileImpl(b, 100, 100000, linearQuantile)
+}
+
+func BenchmarkQuantileSearch_100k_Compression1000(b *testing.B) {
+	benchmarkQuantileImpl(b, 1000, 100000, (*TDigest).Quantile)
+}
+
+func BenchmarkQuantileLinear_100k_Compression1000(b *testing.B) {
+	benchmarkQuantileImpl(b, 1000, 100000, linearQuantile)
 }
 
 func BenchmarkAdd_1k_Ordered(b *testing.B) {
-	benchmarkAdd(b, 1000, &orderedValues{})
+	benchmarkAdd(b, 1000, newSource("ordered"))
 }
 
 func BenchmarkAdd_10k_Ordered(b *testing.B) {
-	benchmarkAdd(b, 10000, &orderedValues{})
+	benchmarkAdd(b, 10000, newSource("ordered"))
 }
 
 func BenchmarkAdd_100k_Ordered(b *testing.B) {
-	benchmarkAdd(b, 100000, &orderedValues{})
+	benchmarkAdd(b, 100000, newSource("ordered"))
+}
+
+func BenchmarkAdd_1M_Ordered(b *testing.B) {
+	benchmarkAdd(b, 1000000, newSource("ordered"))
 }
 
 func BenchmarkQuantile_1k_Ordered(b *testing.B) {
-	benchmarkQuantile(b, 1000, &orderedValues{})
+	benchmarkQuantile(b, 1000, newSource("ordered"))
 }
 
 func BenchmarkQuantile_10k_Ordered(b *testing.B) {
-	benchmarkQuantile(b, 10000, &orderedValues{})
+	benchmarkQuantile(b, 10000, newSource("ordered"))
 }
 
 func BenchmarkQuantile_100k_Ordered(b *testing.B) {
-	benchmarkQuantile(b, 100000, &orderedValues{})
+	benchmarkQuantile(b, 100000, newSource("ordered"))
 }
 
-type zipfValues struct {
-	z *rand.Zipf
-}
-
-func newZipfValues() *zipfValues {
-	r := rand.New(rand.NewSource(rngSeed))
-	z := rand.NewZipf(r, 1.2, 1, 1024*1024)
-	return &zipfValues{
-		z: z,
-	}
-}
-
-func (zv *zipfValues) Next() float64 {
-	return float64(zv.z.Uint64())
+func BenchmarkQuantile_1M_Ordered(b *testing.B) {
+	benchmarkQuantile(b, 1000000, newSource("ordered"))
 }
 
 func BenchmarkAdd_1k_Zipfian(b *testing.B) {
-	benchmarkAdd(b, 1000, newZipfValues())
+	benchmarkAdd(b, 1000, newSource("zipfian"))
 }
 
 func BenchmarkAdd_10k_Zipfian(b *testing.B) {
-	benchmarkAdd(b, 10000, newZipfValues())
+	benchmarkAdd(b, 10000, newSource("zipfian"))
 }
 
 func BenchmarkAdd_100k_Zipfian(b *testing.B) {
-	benchmarkAdd(b, 100000, newZipfValues())
+	benchmarkAdd(b, 100000, newSource("zipfian"))
+}
+
+func BenchmarkAdd_1M_Zipfian(b *testing.B) {
+	benchmarkAdd(b, 1000000, newSource("zipfian"))
 }
 
 func BenchmarkQuantile_1k_Zipfian(b *testing.B) {
-	benchmarkQuantile(b, 1000, newZipfValues())
+	benchmarkQuantile(b, 1000, newSource("zipfian"))
 }
 
 func BenchmarkQuantile_10k_Zipfian(b *testing.B) {
-	benchmarkQuantile(b, 10000, newZipfValues())
+	benchmarkQuantile(b, 10000, newSource("zipfian"))
 }
 
 func BenchmarkQuantile_100k_Zipfian(b *testing.B) {
-	benchmarkQuantile(b, 100000, newZipfValues())
-}
-
-type uniformValues struct {
-	r *rand.Rand
+	benchmarkQuantile(b, 100000, newSource("zipfian"))
 }
 
-func newUniformValues() *uniformValues {
-	return &uniformValues{rand.New(rand.NewSource(rngSeed))}
-}
-
-func (uv *uniformValues) Next() float64 {
-	return uv.r.Float64()
+func BenchmarkQuantile_1M_Zipfian(b *testing.B) {
+	benchmarkQuantile(b, 1000000, newSource("zipfian"))
 }
 
 func BenchmarkAdd_1k_Uniform(b *testing.B) {
-	benchmarkAdd(b, 1000, newUniformValues())
+	benchmarkAdd(b, 1000, newSource("uniform"))
 }
 
 func BenchmarkAdd_10k_Uniform(b *testing.B) {
-	benchmarkAdd(b, 10000, newUniformValues())
+	benchmarkAdd(b, 10000, newSource("uniform"))
 }
 
 func BenchmarkAdd_100k_Uniform(b *testing.B) {
-	benchmarkAdd(b, 100000, newUniformValues())
+	benchmarkAdd(b, 100000, newSource("uniform"))
+}
+
+func BenchmarkAdd_1M_Uniform(b *testing.B) {
+	benchmarkAdd(b, 1000000, newSource("uniform"))
 }
 
 func BenchmarkQuantile_1k_Uniform(b *testing.B) {
-	benchmarkQuantile(b, 1000, newUniformValues())
+	benchmarkQuantile(b, 1000, newSource("uniform"))
 }
 
 func BenchmarkQuantile_10k_Uniform(b *testing.B) {
-	benchmarkQuantile(b, 10000, newUniformValues())
+	benchmarkQuantile(b, 10000, newSource("uniform"))
 }
 
 func BenchmarkQuantile_100k_Uniform(b *testing.B) {
-	benchmarkQuantile(b, 100000, newUniformValues())
-}
-
-type normalValues struct {
-	r *rand.Rand
+	benchmarkQuantile(b, 100000, newSource("uniform"))
 }
 
-func newNormalValues() *normalValues {
-	return &normalValues{rand.New(rand.NewSource(rngSeed))}
-}
-
-func (uv *normalValues) Next() float64 {
-	return uv.r.NormFloat64()
+func BenchmarkQuantile_1M_Uniform(b *testing.B) {
+	benchmarkQuantile(b, 1000000, newSource("uniform"))
 }
 
 func BenchmarkAdd_1k_Normal(b *testing.B) {
-	benchmarkAdd(b, 1000, newNormalValues())
+	benchmarkAdd(b, 1000, newSource("normal"))
 }
 
 func BenchmarkAdd_10k_Normal(b *testing.B) {
-	benchmarkAdd(b, 10000, newNormalValues())
+	benchmarkAdd(b, 10000, newSource("normal"))
 }
 
 func BenchmarkAdd_100k_Normal(b *testing.B) {
-	benchmarkAdd(b, 100000, newNormalValues())
+	benchmarkAdd(b, 100000, newSource("normal"))
+}
+
+func BenchmarkAdd_1M_Normal(b *testing.B) {
+	benchmarkAdd(b, 1000000, newSource("normal"))
 }
 
 func BenchmarkQuantile_1k_Normal(b *testing.B) {
-	benchmarkQuantile(b, 1000, newNormalValues())
+	benchmarkQuantile(b, 1000, newSource("normal"))
 }
 
 func BenchmarkQuantile_10k_Normal(b *testing.B) {
-	benchmarkQuantile(b, 10000, newNormalValues())
+	benchmarkQuantile(b, 10000, newSource("normal"))
 }
 
 func BenchmarkQuantile_100k_Normal(b *testing.B) {
-	benchmarkQuantile(b, 100000, newNormalValues())
+	benchmarkQuantile(b, 100000, newSource("normal"))
+}
+
+func BenchmarkQuantile_1M_Normal(b *testing.B) {
+	benchmarkQuantile(b, 1000000, newSource("normal"))
+}
+
+func BenchmarkClone(b *testing.B) {
+	d := NewWithCompression(100)
+	src := newSource("normal")
+	for i := 0; i < 100000; i++ {
+		d.Add(src.Next(), 1)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = d.Clone()
+	}
 }
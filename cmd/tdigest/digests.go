@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spenczar/tdigest/v2"
+	"github.com/spenczar/tdigest/v2/internal/percentile"
+)
+
+// readInput reads the named file, or stdin if the name is "-".
+func readInput(name string, stdin io.Reader) ([]byte, error) {
+	if name == "-" {
+		return io.ReadAll(stdin)
+	}
+	return os.ReadFile(name)
+}
+
+// isJSON reports whether p looks like a JSON-encoded digest rather than a
+// binary one.
+func isJSON(p []byte) bool {
+	p = bytes.TrimSpace(p)
+	return len(p) > 0 && p[0] == '{'
+}
+
+// decodeDigest decodes p as either a binary or a JSON digest.
+func decodeDigest(p []byte) (*tdigest.TDigest, error) {
+	d := tdigest.New()
+	var err error
+	if isJSON(p) {
+		err = d.UnmarshalJSON(p)
+	} else {
+		err = d.UnmarshalBinary(p)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func readDigest(name string, stdin io.Reader) (*tdigest.TDigest, error) {
+	p, err := readInput(name, stdin)
+	if err != nil {
+		return nil, err
+	}
+	d, err := decodeDigest(p)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return d, nil
+}
+
+// singleInput returns the one file named in fs's arguments, defaulting to
+// stdin.
+func singleInput(fs *flag.FlagSet) (string, error) {
+	switch fs.NArg() {
+	case 0:
+		return "-", nil
+	case 1:
+		return fs.Arg(0), nil
+	default:
+		return "", fmt.Errorf("%s takes a single file, have %d", fs.Name(), fs.NArg())
+	}
+}
+
+func checkFormat(format string) error {
+	if format != "binary" && format != "json" {
+		return fmt.Errorf("unknown format %q", format)
+	}
+	return nil
+}
+
+// writeDigest writes d in the given format to the named file, or to stdout if
+// the name is empty. A version of 0 writes binary digests in the latest
+// version of the binary format.
+func writeDigest(d *tdigest.TDigest, format string, version int, name string, stdout io.Writer) error {
+	var (
+		p   []byte
+		err error
+	)
+	switch format {
+	case "binary":
+		if version == 0 {
+			p, err = d.MarshalBinary()
+		} else {
+			p, err = d.MarshalBinaryVersion(version)
+		}
+	case "json":
+		p, err = d.MarshalJSON()
+		p = append(p, '\n')
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		_, err = stdout.Write(p)
+		return err
+	}
+	return os.WriteFile(name, p, 0644)
+}
+
+func runInspect(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	name, err := singleInput(fs)
+	if err != nil {
+		return err
+	}
+	p, err := readInput(name, stdin)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "file\t%s\n", name)
+	fmt.Fprintf(tw, "size\t%d bytes\n", len(p))
+	if isJSON(p) {
+		fmt.Fprintf(tw, "format\tjson\n")
+	} else {
+		fmt.Fprintf(tw, "format\tbinary\n")
+		// The header is a little-endian int16 magic value followed by an
+		// int32 encoding version.
+		if len(p) >= 2 {
+			fmt.Fprintf(tw, "magic\t0x%04x\n", uint16(binary.LittleEndian.Uint16(p)))
+		}
+		if len(p) >= 6 {
+			fmt.Fprintf(tw, "version\t%d\n", int32(binary.LittleEndian.Uint32(p[2:])))
+		}
+	}
+
+	d, decodeErr := decodeDigest(p)
+	if decodeErr != nil {
+		fmt.Fprintf(tw, "error\t%v\n", decodeErr)
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		return fmt.Errorf("%s: invalid digest", name)
+	}
+
+	centroids := d.Centroids()
+	fmt.Fprintf(tw, "compression\t%g\n", d.Compression())
+	fmt.Fprintf(tw, "count\t%d\n", d.Count())
+	negInf, posInf := d.InfCounts()
+	if nan := d.NaNCount(); nan > 0 {
+		fmt.Fprintf(tw, "nan\t%d\n", nan)
+	}
+	if negInf > 0 {
+		fmt.Fprintf(tw, "-inf\t%d\n", negInf)
+	}
+	if posInf > 0 {
+		fmt.Fprintf(tw, "+inf\t%d\n", posInf)
+	}
+	fmt.Fprintf(tw, "centroids\t%d\n", len(centroids))
+	if len(centroids) > 0 {
+		fmt.Fprintf(tw, "\n")
+		fmt.Fprintf(tw, "#\tmean\tcount\tquantile\n")
+		// Count includes the infinite values, which rank below and above
+		// every centroid.
+		cumulative := negInf
+		for i, c := range centroids {
+			q := (float64(cumulative) + float64(c.Count)/2) / float64(d.Count())
+			fmt.Fprintf(tw, "%d\t%g\t%d\t%.6f\n", i, c.Mean, c.Count, q)
+			cumulative += c.Count
+		}
+	}
+	return tw.Flush()
+}
+
+func runQuery(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	var quantiles, cdf, output string
+	fs := flag.NewFlagSet("query", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&quantiles, "q", "0.5,0.9,0.99,0.999", "comma-separated quantiles to print")
+	fs.StringVar(&cdf, "cdf", "", "comma-separated values to print the CDF at")
+	fs.StringVar(&output, "o", "text", `output format, "text" or "json"`)
+	plot := addPlotFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	qs, err := parseQuantiles(quantiles)
+	if err != nil {
+		return err
+	}
+	xs, err := parseValues(cdf)
+	if err != nil {
+		return err
+	}
+	if output != "text" && output != "json" {
+		return fmt.Errorf("unknown output format %q", output)
+	}
+	if err := plot.check(output); err != nil {
+		return err
+	}
+	name, err := singleInput(fs)
+	if err != nil {
+		return err
+	}
+	d, err := readDigest(name, stdin)
+	if err != nil {
+		return err
+	}
+
+	if output == "json" {
+		out := struct {
+			Count     int64              `json:"count"`
+			Quantiles map[string]float64 `json:"quantiles,omitempty"`
+			CDF       map[string]float64 `json:"cdf,omitempty"`
+		}{Count: d.Count()}
+		if d.Count() > 0 {
+			out.Quantiles = make(map[string]float64, len(qs))
+			for _, q := range qs {
+				out.Quantiles[percentile.Name(q)] = d.Quantile(q)
+			}
+			out.CDF = make(map[string]float64, len(xs))
+			for _, x := range xs {
+				out.CDF[strconv.FormatFloat(x, 'g', -1, 64)] = d.CDF(x)
+			}
+		}
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	}
+
+	bw := bufio.NewWriter(stdout)
+	fmt.Fprintf(bw, "count\t%d\n", d.Count())
+	if d.Count() > 0 {
+		for _, q := range qs {
+			fmt.Fprintf(bw, "%s\t%g\n", percentile.Name(q), d.Quantile(q))
+		}
+		for _, x := range xs {
+			fmt.Fprintf(bw, "cdf(%g)\t%g\n", x, d.CDF(x))
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return plot.write(stdout, d)
+}
+
+func parseValues(s string) ([]float64, error) {
+	var xs []float64
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		x, err := strconv.ParseFloat(field, 64)
+		if err != nil || math.IsNaN(x) {
+			return nil, fmt.Errorf("invalid value %q", field)
+		}
+		xs = append(xs, x)
+	}
+	return xs, nil
+}
+
+func runMerge(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	var (
+		compression  float64
+		format, name string
+	)
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Float64Var(&compression, "c", 0, "compression level of the merged digest (default that of the first input)")
+	fs.StringVar(&format, "format", "binary", `output format, "binary" or "json"`)
+	fs.StringVar(&name, "out", "", "file to write the merged digest to (default stdout)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := checkFormat(format); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("merge needs at least one file")
+	}
+
+	var merged *tdigest.TDigest
+	for _, input := range fs.Args() {
+		d, err := readDigest(input, stdin)
+		if err != nil {
+			return err
+		}
+		if merged == nil {
+			if compression == 0 {
+				compression = d.Compression()
+			}
+			merged = tdigest.NewWithCompression(compression)
+		}
+		d.MergeInto(merged)
+	}
+	return writeDigest(merged, format, 0, name, stdout)
+}
+
+func runConvert(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	var (
+		format, name string
+		version      int
+	)
+	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&format, "format", "json", `output format, "binary" or "json"`)
+	fs.IntVar(&version, "version", 0, "version of the binary format to write (default the latest)")
+	fs.StringVar(&name, "out", "", "file to write the converted digest to (default stdout)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := checkFormat(format); err != nil {
+		return err
+	}
+	input, err := singleInput(fs)
+	if err != nil {
+		return err
+	}
+	d, err := readDigest(input, stdin)
+	if err != nil {
+		return err
+	}
+	return writeDigest(d, format, version, name, stdout)
+}
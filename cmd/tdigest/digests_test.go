@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spenczar/tdigest/v2"
+)
+
+// writeTestDigest stores a digest of the values [from, to) in a temporary
+// file, and returns its name.
+func writeTestDigest(t *testing.T, from, to int) string {
+	t.Helper()
+	d := tdigest.New()
+	for i := from; i < to; i++ {
+		d.Add(float64(i), 1)
+	}
+	p, err := d.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary err: %v", err)
+	}
+	name := filepath.Join(t.TempDir(), "digest.bin")
+	if err := os.WriteFile(name, p, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestInspect(t *testing.T) {
+	name := writeTestDigest(t, 0, 10)
+	var stdout, stderr bytes.Buffer
+	if err := run([]string{"inspect", name}, nil, &stdout, &stderr); err != nil {
+		t.Fatalf("run err: %v", err)
+	}
+	for _, want := range []string{"format       binary", "magic        0x0c80", "version      2", "count        10"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("inspect output missing %q:\n%s", want, stdout.String())
+		}
+	}
+}
+
+func TestInspectNonFinite(t *testing.T) {
+	d := tdigest.New(tdigest.WithNonFinite())
+	d.Add(math.Inf(-1), 2)
+	d.Add(1, 1)
+	d.Add(2, 1)
+	d.Add(math.Inf(+1), 4)
+	p, err := d.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary err: %v", err)
+	}
+	var stdout, stderr bytes.Buffer
+	if err := run([]string{"inspect"}, bytes.NewReader(p), &stdout, &stderr); err != nil {
+		t.Fatalf("run err: %v", err)
+	}
+	// The centroids rank after the two -Inf values, among eight in all.
+	for _, want := range []string{"-inf         2", "+inf         4", "0  1     1      0.312500", "1  2     1      0.437500"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("inspect output missing %q:\n%s", want, stdout.String())
+		}
+	}
+}
+
+func TestInspectInvalid(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	stdin := bytes.NewReader([]byte{0x80, 0x0c, 0x09, 0x00, 0x00, 0x00})
+	err := run([]string{"inspect"}, stdin, &stdout, &stderr)
+	if err == nil || err.Error() != "-: invalid digest" {
+		t.Errorf("wrong error, have=%v, want=%q", err, "-: invalid digest")
+	}
+	want := "error    data corruption detected: invalid encoding version 9"
+	if !strings.Contains(stdout.String(), want) {
+		t.Errorf("inspect output missing %q:\n%s", want, stdout.String())
+	}
+}
+
+func TestQuery(t *testing.T) {
+	name := writeTestDigest(t, 0, 1000)
+	var stdout, stderr bytes.Buffer
+	args := []string{"query", "-q", "0.5", "-cdf", "250", "-o", "json", name}
+	if err := run(args, nil, &stdout, &stderr); err != nil {
+		t.Fatalf("run err: %v", err)
+	}
+	var have struct {
+		Count     int64
+		Quantiles map[string]float64
+		CDF       map[string]float64
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &have); err != nil {
+		t.Fatalf("invalid json output %q: %v", stdout.String(), err)
+	}
+	if have.Count != 1000 {
+		t.Errorf("wrong count, have=%d, want=%d", have.Count, 1000)
+	}
+	if p50 := have.Quantiles["p50"]; p50 < 450 || p50 > 550 {
+		t.Errorf("wrong p50, have=%v, want about 500", p50)
+	}
+	if cdf := have.CDF["250"]; cdf < 0.2 || cdf > 0.3 {
+		t.Errorf("wrong cdf(250), have=%v, want about 0.25", cdf)
+	}
+
+	args = []string{"query", "-cdf", "NaN", name}
+	if err := run(args, nil, &stdout, &stderr); err == nil || err.Error() != `invalid value "NaN"` {
+		t.Errorf("wrong error, have=%v, want=%q", err, `invalid value "NaN"`)
+	}
+}
+
+func TestMergeAndConvert(t *testing.T) {
+	a, b := writeTestDigest(t, 0, 500), writeTestDigest(t, 500, 1000)
+	merged := filepath.Join(t.TempDir(), "merged.json")
+	var stdout, stderr bytes.Buffer
+	if err := run([]string{"merge", "-format", "json", "-out", merged, a, b}, nil, &stdout, &stderr); err != nil {
+		t.Fatalf("merge err: %v", err)
+	}
+
+	// Convert the JSON output back to binary, through stdin and stdout.
+	p, err := os.ReadFile(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := run([]string{"convert", "-format", "binary"}, bytes.NewReader(p), &stdout, &stderr); err != nil {
+		t.Fatalf("convert err: %v", err)
+	}
+	d := tdigest.New()
+	if err := d.UnmarshalBinary(stdout.Bytes()); err != nil {
+		t.Fatalf("converted digest is invalid: %v", err)
+	}
+	if d.Count() != 1000 {
+		t.Errorf("wrong merged count, have=%d, want=%d", d.Count(), 1000)
+	}
+}
+
+func TestConvertVersion(t *testing.T) {
+	name := writeTestDigest(t, 0, 10)
+	var stdout, stderr bytes.Buffer
+	if err := run([]string{"convert", "-format", "binary", "-version", "1", name}, nil, &stdout, &stderr); err != nil {
+		t.Fatalf("convert err: %v", err)
+	}
+	if version := binary.LittleEndian.Uint32(stdout.Bytes()[2:]); version != 1 {
+		t.Errorf("wrong version, have=%d, want=%d", version, 1)
+	}
+	d := tdigest.New()
+	if err := d.UnmarshalBinary(stdout.Bytes()); err != nil {
+		t.Fatalf("converted digest is invalid: %v", err)
+	}
+	if d.Count() != 10 {
+		t.Errorf("wrong count, have=%d, want=%d", d.Count(), 10)
+	}
+
+	err := run([]string{"convert", "-format", "binary", "-version", "9", name}, nil, &stdout, &stderr)
+	if want := "unknown encoding version 9"; err == nil || err.Error() != want {
+		t.Errorf("wrong error, have=%v, want=%q", err, want)
+	}
+}
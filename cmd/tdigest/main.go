@@ -0,0 +1,326 @@
+// Command tdigest computes approximate quantiles of a stream of numbers, and
+// works with t-digests stored on disk.
+//
+// Usage:
+//
+//	tdigest [flags] [file ...]
+//	tdigest inspect [file]
+//	tdigest query [-q quantiles] [-cdf values] [-o format] [-plot kind] [file]
+//	tdigest merge [-c compression] [-format format] [-out file] file ...
+//	tdigest convert [-format format] [-version n] [-out file] [file]
+//
+// Numbers are read one per line from each named file, or from standard input
+// if there are none or the name is "-". With -f, numbers are instead read from one field of each
+// line, which is useful for CSV and TSV files and for log lines. The count,
+// minimum, maximum and mean of the numbers are printed along with the
+// requested quantiles.
+//
+// The flags are:
+//
+//	-c compression
+//		compression level of the t-digest (default 100)
+//	-q quantiles
+//		comma-separated quantiles to print (default "0.5,0.9,0.99,0.999")
+//	-f field
+//		1-based index of the field holding the number; 0 uses the whole line
+//	-d delimiter
+//		field delimiter used with -f; by default fields are separated by
+//		whitespace
+//	-header
+//		skip the first line of each input
+//	-skip-invalid
+//		skip lines which do not hold a valid number, instead of failing
+//	-o format
+//		output format, either "text" or "json" (default "text")
+//	-plot kind
+//		after a text summary, draw a plot of the data: either "hist" for a
+//		histogram or "cdf" for its cumulative distribution function
+//	-width columns, -height rows
+//		size of the plot (default 60 by 12)
+//	-log
+//		use a logarithmic x axis for the plot
+//	-ascii
+//		draw the plot using only ASCII characters
+//
+// The subcommands work with t-digests stored in files, in either the binary
+// format written by TDigest.MarshalBinary or the JSON format written by
+// TDigest.MarshalJSON; the format of an input is detected automatically. A
+// file name of "-", or no file name for subcommands taking a single file,
+// reads from standard input.
+//
+// The inspect subcommand prints the header, compression level and centroids
+// of a stored t-digest, and reports why it is invalid if it can't be decoded.
+//
+// The query subcommand prints quantiles of a stored t-digest, and with -cdf,
+// the fraction of its data at or below each of a comma-separated list of
+// values. It accepts the same plotting flags as the main command.
+//
+// The merge subcommand combines several stored t-digests into one, using the
+// compression level given with -c or else that of the first input.
+//
+// The convert subcommand rewrites a stored t-digest in the format given with
+// -format, which is "binary" or "json". Binary t-digests are written in the
+// latest version of the binary format, or in the version given with -version
+// for programs using an older release of the package.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spenczar/tdigest/v2"
+	"github.com/spenczar/tdigest/v2/internal/percentile"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(os.Stderr, "tdigest: %v\n", err)
+		}
+		os.Exit(2)
+	}
+}
+
+type config struct {
+	compression float64
+	quantiles   []float64
+	field       int
+	delimiter   string
+	header      bool
+	skipInvalid bool
+	output      string
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "inspect":
+			return runInspect(args[1:], stdin, stdout, stderr)
+		case "query":
+			return runQuery(args[1:], stdin, stdout, stderr)
+		case "merge":
+			return runMerge(args[1:], stdin, stdout, stderr)
+		case "convert":
+			return runConvert(args[1:], stdin, stdout, stderr)
+		}
+	}
+	return runStats(args, stdin, stdout, stderr)
+}
+
+// runStats summarizes numbers read from text files.
+func runStats(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	var (
+		cfg       config
+		quantiles string
+	)
+	fs := flag.NewFlagSet("tdigest", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Float64Var(&cfg.compression, "c", 100, "compression level of the t-digest")
+	fs.StringVar(&quantiles, "q", "0.5,0.9,0.99,0.999", "comma-separated quantiles to print")
+	fs.IntVar(&cfg.field, "f", 0, "1-based index of the field holding the number; 0 uses the whole line")
+	fs.StringVar(&cfg.delimiter, "d", "", "field delimiter used with -f (default whitespace)")
+	fs.BoolVar(&cfg.header, "header", false, "skip the first line of each input")
+	fs.BoolVar(&cfg.skipInvalid, "skip-invalid", false, "skip lines which do not hold a valid number")
+	fs.StringVar(&cfg.output, "o", "text", `output format, "text" or "json"`)
+	plot := addPlotFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var err error
+	if cfg.quantiles, err = parseQuantiles(quantiles); err != nil {
+		return err
+	}
+	if cfg.field < 0 {
+		return fmt.Errorf("invalid field %d", cfg.field)
+	}
+	if cfg.output != "text" && cfg.output != "json" {
+		return fmt.Errorf("unknown output format %q", cfg.output)
+	}
+	if err := plot.check(cfg.output); err != nil {
+		return err
+	}
+
+	s := newSummary(cfg.compression)
+	if fs.NArg() == 0 {
+		if err := s.read(stdin, "<stdin>", cfg); err != nil {
+			return err
+		}
+	}
+	for _, name := range fs.Args() {
+		if name == "-" {
+			if err := s.read(stdin, "<stdin>", cfg); err != nil {
+				return err
+			}
+			continue
+		}
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		err = s.read(f, name, cfg)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if cfg.output == "json" {
+		return s.writeJSON(stdout, cfg.quantiles)
+	}
+	if err := s.writeText(stdout, cfg.quantiles); err != nil {
+		return err
+	}
+	return plot.write(stdout, s.td)
+}
+
+func parseQuantiles(s string) ([]float64, error) {
+	var qs []float64
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		q, err := strconv.ParseFloat(field, 64)
+		if err != nil || !(q >= 0 && q <= 1) {
+			return nil, fmt.Errorf("invalid quantile %q: must be a number between 0 and 1", field)
+		}
+		qs = append(qs, q)
+	}
+	return qs, nil
+}
+
+// summary accumulates numbers into a TDigest, along with exact statistics
+// which the TDigest doesn't keep.
+type summary struct {
+	td       *tdigest.TDigest
+	count    int64
+	skipped  int64
+	sum      float64
+	min, max float64
+}
+
+func newSummary(compression float64) *summary {
+	return &summary{
+		td:  tdigest.NewWithCompression(compression),
+		min: math.Inf(+1),
+		max: math.Inf(-1),
+	}
+}
+
+func (s *summary) add(v float64) {
+	s.td.Add(v, 1)
+	s.count++
+	s.sum += v
+	if v < s.min {
+		s.min = v
+	}
+	if v > s.max {
+		s.max = v
+	}
+}
+
+// read adds every number in r to s. name identifies r in error messages.
+func (s *summary) read(r io.Reader, name string, cfg config) error {
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		if lineNum == 1 && cfg.header {
+			continue
+		}
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		v, err := parseLine(line, cfg.field, cfg.delimiter)
+		if err == nil && (math.IsNaN(v) || math.IsInf(v, 0)) {
+			err = fmt.Errorf("%v is not a finite number", v)
+		}
+		if err != nil {
+			if cfg.skipInvalid {
+				s.skipped++
+				continue
+			}
+			return fmt.Errorf("%s:%d: %v", name, lineNum, err)
+		}
+		s.add(v)
+	}
+	return scanner.Err()
+}
+
+// parseLine extracts the number in the given 1-based field of line. A field of
+// 0 means the whole line.
+func parseLine(line string, field int, delimiter string) (float64, error) {
+	text := line
+	if field > 0 {
+		var fields []string
+		if delimiter == "" {
+			fields = strings.Fields(line)
+		} else {
+			fields = strings.Split(line, delimiter)
+		}
+		if field > len(fields) {
+			return 0, fmt.Errorf("line has %d fields, cannot read field %d", len(fields), field)
+		}
+		text = fields[field-1]
+	}
+	text = strings.Trim(strings.TrimSpace(text), `"`)
+	v, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", text)
+	}
+	return v, nil
+}
+
+func (s *summary) writeText(w io.Writer, qs []float64) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "count\t%d\n", s.count)
+	if s.skipped > 0 {
+		fmt.Fprintf(bw, "skipped\t%d\n", s.skipped)
+	}
+	if s.count > 0 {
+		fmt.Fprintf(bw, "min\t%g\n", s.min)
+		fmt.Fprintf(bw, "max\t%g\n", s.max)
+		fmt.Fprintf(bw, "mean\t%g\n", s.sum/float64(s.count))
+		for _, q := range qs {
+			fmt.Fprintf(bw, "%s\t%g\n", percentile.Name(q), s.td.Quantile(q))
+		}
+	}
+	return bw.Flush()
+}
+
+type jsonSummary struct {
+	Count     int64              `json:"count"`
+	Skipped   int64              `json:"skipped,omitempty"`
+	Min       *float64           `json:"min,omitempty"`
+	Max       *float64           `json:"max,omitempty"`
+	Mean      *float64           `json:"mean,omitempty"`
+	Quantiles map[string]float64 `json:"quantiles,omitempty"`
+}
+
+func (s *summary) writeJSON(w io.Writer, qs []float64) error {
+	out := jsonSummary{
+		Count:   s.count,
+		Skipped: s.skipped,
+	}
+	if s.count > 0 {
+		mean := s.sum / float64(s.count)
+		out.Min, out.Max, out.Mean = &s.min, &s.max, &mean
+		out.Quantiles = make(map[string]float64, len(qs))
+		for _, q := range qs {
+			out.Quantiles[percentile.Name(q)] = s.td.Quantile(q)
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
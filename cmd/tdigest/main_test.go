@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRunText(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	stdin := strings.NewReader("1\n2\n\n3\n4\n")
+	if err := run([]string{"-q", "0.5"}, stdin, &stdout, &stderr); err != nil {
+		t.Fatalf("run err: %v", err)
+	}
+	want := "count\t4\nmin\t1\nmax\t4\nmean\t2.5\np50\t2.5\n"
+	if have := stdout.String(); have != want {
+		t.Errorf("wrong output, have=%q, want=%q", have, want)
+	}
+}
+
+func TestRunStdinName(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	stdin := strings.NewReader("1\n2\n3\n")
+	if err := run([]string{"-q", "0.5", "-"}, stdin, &stdout, &stderr); err != nil {
+		t.Fatalf("run err: %v", err)
+	}
+	if want := "count\t3\n"; !strings.HasPrefix(stdout.String(), want) {
+		t.Errorf("wrong output, have=%q, want prefix %q", stdout.String(), want)
+	}
+}
+
+func TestRunJSON(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	stdin := strings.NewReader("name,latency\na,10\nb,bogus\nc,30\n")
+	args := []string{"-f", "2", "-d", ",", "-header", "-skip-invalid", "-o", "json", "-q", "0.5,0.999"}
+	if err := run(args, stdin, &stdout, &stderr); err != nil {
+		t.Fatalf("run err: %v", err)
+	}
+	var have jsonSummary
+	if err := json.Unmarshal(stdout.Bytes(), &have); err != nil {
+		t.Fatalf("invalid json output %q: %v", stdout.String(), err)
+	}
+	if have.Count != 2 || have.Skipped != 1 {
+		t.Errorf("wrong counts, have count=%d skipped=%d, want count=2 skipped=1", have.Count, have.Skipped)
+	}
+	if have.Min == nil || *have.Min != 10 || have.Max == nil || *have.Max != 30 {
+		t.Errorf("wrong min and max in %s", stdout.String())
+	}
+	if _, ok := have.Quantiles["p99.9"]; !ok {
+		t.Errorf("missing p99.9 quantile in %s", stdout.String())
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	testcase := func(args []string, stdin string, wantErr string) func(*testing.T) {
+		return func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			err := run(args, strings.NewReader(stdin), &stdout, &stderr)
+			if err == nil {
+				t.Fatalf("expected err=%q, got nil", wantErr)
+			}
+			if err.Error() != wantErr {
+				t.Errorf("wrong error, want=%q, have=%q", wantErr, err.Error())
+			}
+		}
+	}
+	t.Run("invalid number", testcase(nil, "1\nfoo\n", `<stdin>:2: invalid number "foo"`))
+	t.Run("missing field", testcase([]string{"-f", "3"}, "1 2\n", "<stdin>:1: line has 2 fields, cannot read field 3"))
+	t.Run("bad quantile", testcase([]string{"-q", "1.5"}, "", `invalid quantile "1.5": must be a number between 0 and 1`))
+	t.Run("NaN quantile", testcase([]string{"-q", "NaN"}, "", `invalid quantile "NaN": must be a number between 0 and 1`))
+	t.Run("bad output", testcase([]string{"-o", "xml"}, "", `unknown output format "xml"`))
+}
+
+func TestParseLine(t *testing.T) {
+	type testcase struct {
+		line      string
+		field     int
+		delimiter string
+		want      float64
+	}
+	testcases := []testcase{
+		{"1.5", 0, "", 1.5},
+		{" 2e3 ", 0, "", 2000},
+		{"GET /index 200 0.25", 4, "", 0.25},
+		{"a\t7\tb", 2, "\t", 7},
+		{`x,"8",y`, 2, ",", 8},
+	}
+	for i, tc := range testcases {
+		have, err := parseLine(tc.line, tc.field, tc.delimiter)
+		if err != nil {
+			t.Errorf("parseLine err test=%d: %v", i, err)
+		} else if have != tc.want {
+			t.Errorf("parseLine wrong test=%d, have=%v, want=%v", i, have, tc.want)
+		}
+	}
+}
+
+func TestRunPlot(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	stdin := strings.NewReader("1\n2\n3\n4\n")
+	args := []string{"-q", "", "-plot", "hist", "-width", "8", "-height", "2", "-ascii"}
+	if err := run(args, stdin, &stdout, &stderr); err != nil {
+		t.Fatalf("run err: %v", err)
+	}
+	summary := "count\t4\nmin\t1\nmax\t4\nmean\t2.5\n\n"
+	if have := stdout.String(); !strings.HasPrefix(have, summary) {
+		t.Fatalf("wrong output, have:\n%s\nwant summary:\n%s", have, summary)
+	}
+	// two rows of bars, the axis and its labels
+	plot := strings.Split(strings.TrimPrefix(stdout.String(), summary), "\n")
+	if len(plot) != 5 || !strings.HasSuffix(plot[2], "+--------") {
+		t.Errorf("wrong plot:\n%s", strings.Join(plot, "\n"))
+	}
+
+	err := run([]string{"-plot", "hist", "-o", "json"}, strings.NewReader(""), &stdout, &stderr)
+	if err == nil {
+		t.Error("expected error plotting with json output, got nil")
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/spenczar/tdigest/v2"
+)
+
+// plotFlags holds the flags controlling the optional plot printed after a
+// text summary.
+type plotFlags struct {
+	kind string
+	opts tdigest.PlotOptions
+}
+
+func addPlotFlags(fs *flag.FlagSet) *plotFlags {
+	p := new(plotFlags)
+	fs.StringVar(&p.kind, "plot", "", `draw a plot of the data, "hist" or "cdf"`)
+	fs.IntVar(&p.opts.Width, "width", 60, "width of the plot in columns")
+	fs.IntVar(&p.opts.Height, "height", 12, "height of the plot in rows")
+	fs.BoolVar(&p.opts.LogScale, "log", false, "use a logarithmic x axis for the plot")
+	fs.BoolVar(&p.opts.ASCII, "ascii", false, "draw the plot using only ASCII characters")
+	return p
+}
+
+func (p *plotFlags) check(output string) error {
+	switch {
+	case p.kind != "" && p.kind != "hist" && p.kind != "cdf":
+		return fmt.Errorf("unknown plot %q", p.kind)
+	case p.kind != "" && output != "text":
+		return fmt.Errorf("-plot can only be used with text output")
+	}
+	return nil
+}
+
+func (p *plotFlags) write(w io.Writer, d *tdigest.TDigest) error {
+	if p.kind == "" || d.Count() == 0 {
+		return nil
+	}
+	if _, err := fmt.Fprintln(w); err != nil {
+		return err
+	}
+	if p.kind == "cdf" {
+		return d.WriteCDF(w, p.opts)
+	}
+	return d.WriteHistogram(w, p.opts)
+}
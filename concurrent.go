@@ -0,0 +1,87 @@
+package tdigest
+
+import "sync"
+
+// A Concurrent is a TDigest which is safe for concurrent use by multiple
+// goroutines. It is meant for accumulating data which is periodically
+// flushed: Snapshot hands back everything added so far and starts afresh,
+// without losing any values added concurrently.
+//
+//	latency := tdigest.NewConcurrent()
+//	go func() {
+//		for range time.Tick(10 * time.Second) {
+//			report(latency.Snapshot())
+//		}
+//	}()
+//	...
+//	latency.Add(elapsed.Seconds(), 1)
+type Concurrent struct {
+	mu sync.Mutex
+	d  *TDigest
+}
+
+// NewConcurrent produces a new, empty Concurrent. Each TDigest it starts is
+// configured with the provided options.
+func NewConcurrent(opts ...Option) *Concurrent {
+	return &Concurrent{d: New(opts...)}
+}
+
+// Add adds a value to the current TDigest. See TDigest.Add.
+func (c *Concurrent) Add(val float64, weight int) {
+	c.mu.Lock()
+	c.d.Add(val, weight)
+	c.mu.Unlock()
+}
+
+// TryAdd adds a value to the current TDigest, returning an error if it cannot
+// be added. See TDigest.TryAdd.
+func (c *Concurrent) TryAdd(val float64, weight int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.d.TryAdd(val, weight)
+}
+
+// Quantile estimates the qth quantile of the current TDigest. See
+// TDigest.Quantile.
+func (c *Concurrent) Quantile(q float64) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.d.Quantile(q)
+}
+
+// CDF estimates the fraction of the current TDigest's data which is less than
+// or equal to x. See TDigest.CDF.
+func (c *Concurrent) CDF(x float64) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.d.CDF(x)
+}
+
+// Count returns the total weight of all data in the current TDigest.
+func (c *Concurrent) Count() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.d.Count()
+}
+
+// Snapshot returns the current TDigest, holding all data added since c was
+// created or last flushed, and replaces it with an empty one of the same
+// configuration. The returned TDigest is no longer used by c, so the caller
+// owns it.
+func (c *Concurrent) Snapshot() *TDigest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d := c.d
+	c.d = d.emptyCopy()
+	return d
+}
+
+// Swap returns the current TDigest and replaces it with d, which c takes
+// ownership of: the caller must not use d afterwards.
+func (c *Concurrent) Swap(d *TDigest) *TDigest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	old := c.d
+	c.d = d
+	return old
+}
@@ -0,0 +1,58 @@
+package tdigest
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestConcurrentSnapshot(t *testing.T) {
+	c := NewConcurrent(WithCompression(50))
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 5000; i++ {
+				c.Add(float64(i), 1)
+			}
+		}()
+	}
+
+	// Flush while values are being added; none should be lost.
+	total := New()
+	for i := 0; i < 20; i++ {
+		d := c.Snapshot()
+		if d.Compression() != 50 {
+			t.Fatalf("snapshot has wrong compression, have=%v, want=%v", d.Compression(), 50)
+		}
+		d.MergeInto(total)
+	}
+	wg.Wait()
+	c.Snapshot().MergeInto(total)
+
+	if have := total.Count(); have != 20000 {
+		t.Errorf("snapshots lost values, have=%d, want=%d", have, 20000)
+	}
+	if have := c.Count(); have != 0 {
+		t.Errorf("Concurrent not reset by Snapshot, count=%d", have)
+	}
+	c.Add(1, 1)
+	if have := c.Snapshot().Compression(); have != 50 {
+		t.Errorf("Concurrent lost configuration after Snapshot, compression=%v", have)
+	}
+}
+
+func TestConcurrentSwap(t *testing.T) {
+	c := NewConcurrent()
+	c.Add(1, 1)
+
+	next := New()
+	next.Add(2, 3)
+	old := c.Swap(next)
+	if old.Count() != 1 || old.Quantile(0.5) != 1 {
+		t.Errorf("Swap returned wrong TDigest, count=%d median=%v", old.Count(), old.Quantile(0.5))
+	}
+	if c.Count() != 3 || c.Quantile(0.5) != 2 || c.CDF(2) != 0.5 {
+		t.Errorf("Swap did not install new TDigest, count=%d median=%v", c.Count(), c.Quantile(0.5))
+	}
+}
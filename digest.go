@@ -0,0 +1,123 @@
+package tdigest
+
+import "math"
+
+// Number is the set of types which a Digest can hold: any integer or
+// floating-point type, including named types like time.Duration.
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64
+}
+
+// A Digest is a TDigest of values of type T. Its methods take and return T
+// rather than float64, so that values like sizes or durations don't need to
+// be converted by hand:
+//
+//	sizes := tdigest.NewDigest[int64]()
+//	sizes.Add(resp.ContentLength, 1)
+//	...
+//	p99 := sizes.Quantile(0.99) // an int64
+//
+// Values are held as float64 internally, so integers with a magnitude above
+// 2^53 lose precision. For integer types, estimates are rounded to the
+// nearest integer.
+//
+// Like a TDigest, a Digest is not safe for concurrent use.
+type Digest[T Number] struct {
+	td *TDigest
+}
+
+// NewDigest produces a new, empty Digest, configured with the provided
+// options.
+func NewDigest[T Number](opts ...Option) *Digest[T] {
+	return &Digest[T]{td: New(opts...)}
+}
+
+// Add adds a value to g. See TDigest.Add.
+func (g *Digest[T]) Add(val T, weight int) {
+	g.td.Add(float64(val), weight)
+}
+
+// TryAdd adds a value to g, returning an error if it cannot be added. See
+// TDigest.TryAdd.
+func (g *Digest[T]) TryAdd(val T, weight int) error {
+	return g.td.TryAdd(float64(val), weight)
+}
+
+// Quantile estimates the qth quantile of g's data. See TDigest.Quantile.
+//
+// Calling Quantile on a Digest with no data will return NaN if T is a
+// floating-point type, and 0 otherwise.
+func (g *Digest[T]) Quantile(q float64) T {
+	return fromFloat[T](g.td.Quantile(q))
+}
+
+// CDF estimates the fraction of g's data which is less than or equal to val.
+// See TDigest.CDF.
+func (g *Digest[T]) CDF(val T) float64 {
+	return g.td.CDF(float64(val))
+}
+
+// Count returns the total weight of all data added to g.
+func (g *Digest[T]) Count() int64 {
+	return g.td.Count()
+}
+
+// Min returns the smallest value added to g. Calling Min on a Digest with no
+// data will return NaN if T is a floating-point type, and 0 otherwise.
+func (g *Digest[T]) Min() T {
+	return fromFloat[T](g.td.Min())
+}
+
+// Max returns the largest value added to g. Calling Max on a Digest with no
+// data will return NaN if T is a floating-point type, and 0 otherwise.
+func (g *Digest[T]) Max() T {
+	return fromFloat[T](g.td.Max())
+}
+
+// MergeInto merges all of g's data into other. See TDigest.MergeInto.
+func (g *Digest[T]) MergeInto(other *Digest[T]) {
+	g.td.MergeInto(other.td)
+}
+
+// Reset removes all data from g, keeping its configuration.
+func (g *Digest[T]) Reset() {
+	g.td.Reset()
+}
+
+// TDigest returns the TDigest that holds g's data, for use with the parts of
+// the package which work on a TDigest, like serialization or a Registry.
+// Changes to it are reflected in g.
+func (g *Digest[T]) TDigest() *TDigest {
+	return g.td
+}
+
+// MarshalBinary serializes g in the same format as TDigest.MarshalBinary.
+func (g *Digest[T]) MarshalBinary() ([]byte, error) {
+	return g.td.MarshalBinary()
+}
+
+// UnmarshalBinary populates g with the parsed contents of p, which should
+// have been created with a call to MarshalBinary on a Digest or a TDigest.
+func (g *Digest[T]) UnmarshalBinary(p []byte) error {
+	return g.td.UnmarshalBinary(p)
+}
+
+// fromFloat converts an estimate into a T. Integer types have no NaN, so an
+// estimate from an empty digest becomes 0.
+func fromFloat[T Number](v float64) T {
+	if isInteger[T]() {
+		if math.IsNaN(v) {
+			return 0
+		}
+		return T(math.Round(v))
+	}
+	return T(v)
+}
+
+// isInteger reports whether T is an integer type.
+func isInteger[T Number]() bool {
+	half := 0.5
+	return T(half) == 0
+}
@@ -0,0 +1,67 @@
+package tdigest
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDigestInteger(t *testing.T) {
+	d := NewDigest[int64]()
+	if have := d.Quantile(0.5); have != 0 {
+		t.Errorf("Digest.Quantile of empty integer digest wrong, have=%v, want=0", have)
+	}
+	for i := int64(1); i <= 100; i++ {
+		d.Add(i, 1)
+	}
+	if d.Count() != 100 || d.Min() != 1 || d.Max() != 100 {
+		t.Errorf("Digest has wrong state, count=%d min=%d max=%d", d.Count(), d.Min(), d.Max())
+	}
+	if have, want := d.Quantile(0.5), int64(math.Round(d.TDigest().Quantile(0.5))); have != want {
+		t.Errorf("Digest.Quantile should round, have=%v, want=%v", have, want)
+	}
+	if have := d.CDF(100); have <= 0.99 || have > 1 {
+		t.Errorf("Digest.CDF(100) wrong, have=%v", have)
+	}
+}
+
+func TestDigestFloat(t *testing.T) {
+	d := NewDigest[float32]()
+	if have := d.Quantile(0.5); !math.IsNaN(float64(have)) {
+		t.Errorf("Digest.Quantile of empty float digest wrong, have=%v, want=NaN", have)
+	}
+	d.Add(1.5, 1)
+	d.Add(2.5, 1)
+	if have := d.Quantile(0); have != 1.5 {
+		t.Errorf("Digest.Quantile should not round floats, have=%v, want=%v", have, 1.5)
+	}
+	if err := d.TryAdd(float32(math.NaN()), 1); err == nil {
+		t.Error("expected error adding NaN, got nil")
+	}
+}
+
+func TestDigestNamedType(t *testing.T) {
+	a, b := NewDigest[time.Duration](), NewDigest[time.Duration]()
+	a.Add(time.Second, 1)
+	b.Add(3*time.Second, 1)
+	a.MergeInto(b)
+	if have := b.Max(); have != 3*time.Second {
+		t.Errorf("Digest.Max wrong after merge, have=%v, want=%v", have, 3*time.Second)
+	}
+
+	p, err := b.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary err: %v", err)
+	}
+	c := NewDigest[time.Duration]()
+	if err := c.UnmarshalBinary(p); err != nil {
+		t.Fatalf("UnmarshalBinary err: %v", err)
+	}
+	if c.Count() != 2 || c.Min() != time.Second {
+		t.Errorf("Digest round trip wrong, count=%d min=%v", c.Count(), c.Min())
+	}
+	c.Reset()
+	if c.Count() != 0 {
+		t.Errorf("Digest.Reset left data, count=%d", c.Count())
+	}
+}
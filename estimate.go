@@ -0,0 +1,110 @@
+package tdigest
+
+import "math"
+
+// A QuantileEstimate is an estimate of a quantile along with an indication of
+// how far it may be from the true value.
+type QuantileEstimate struct {
+	// Value is the estimate. It is equal to the result of Quantile.
+	Value float64
+	// Lower and Upper bound the true quantile, by the values at the ends of
+	// the range of ranks covered by the centroid holding the quantile's rank
+	// and its neighbours on each side. They grow further apart as those
+	// centroids grow heavier.
+	//
+	// The bounds are estimates too. The samples of neighbouring centroids
+	// can be interleaved, and the bounds do not account for that.
+	Lower, Upper float64
+	// Exact is set if the estimate is not interpolated from centroids,
+	// because the TDigest is in exact mode, Value is the sample held by a
+	// centroid with a weight of 1 at the quantile's rank, or all samples are
+	// identical. Value is then the exact quantile, and Lower and Upper are
+	// equal to it. An Interpolation like Linear may place Value between
+	// samples even when the rank falls on a single sample, and then Exact is
+	// not set.
+	Exact bool
+	// TailCount is the number of samples from the centroid holding the
+	// quantile's rank out to the nearer end of the distribution: the low end
+	// for q < 0.5, and the high end otherwise. Estimates of extreme
+	// quantiles resting on only a handful of samples have a low TailCount.
+	TailCount int64
+}
+
+// EstimateQuantile estimates the qth quantile of the dataset, like Quantile,
+// and also reports how trustworthy the estimate is.
+//
+// Calling EstimateQuantile on a TDigest with no data will return an estimate
+// with NaN values.
+func (d *TDigest) EstimateQuantile(q float64) QuantileEstimate {
+	if d.Count() == 0 {
+		nan := math.NaN()
+		return QuantileEstimate{Value: nan, Lower: nan, Upper: nan}
+	}
+	if q < 0 {
+		q = 0
+	} else if q > 1 {
+		q = 1
+	}
+	var (
+		value = d.Quantile(q)
+		lower = q < 0.5
+	)
+	if math.IsInf(value, 0) {
+		// The rank falls within one of the tails of infinite values.
+		tail := d.negInfCount
+		if value > 0 {
+			tail = d.posInfCount
+		}
+		return QuantileEstimate{Value: value, Lower: value, Upper: value, Exact: true, TailCount: tail}
+	}
+
+	// Find the centroid holding the rank, as Quantile does.
+	rank := q*float64(d.Count()) - float64(d.negInfCount)
+	k, before := d.searchCumulative(rank)
+	n := len(d.centroids)
+	if k == n {
+		k--
+		before -= d.centroids[k].count
+	}
+	c := d.centroids[k]
+
+	// The samples of a centroid may be spread among those of its neighbours,
+	// so the true quantile could be the value at any rank within the
+	// centroid holding it and the two either side.
+	lo, hi := before, before+c.count
+	if k > 0 {
+		lo -= d.centroids[k-1].count
+	}
+	if k < n-1 {
+		hi += d.centroids[k+1].count
+	}
+	est := QuantileEstimate{
+		Value: value,
+		Lower: d.finiteQuantile(float64(lo)),
+		Upper: d.finiteQuantile(float64(hi)),
+	}
+	if lower {
+		est.TailCount = d.negInfCount + before + c.count
+	} else {
+		est.TailCount = d.posInfCount + d.countTotal - before
+	}
+
+	if d.exact {
+		est.Lower, est.Upper, est.Exact = value, value, true
+		return est
+	}
+	if (c.count == 1 && value == c.mean) || d.min == d.max {
+		est.Lower, est.Upper, est.Exact = value, value, true
+		return est
+	}
+	est.Lower = math.Min(est.Lower, value)
+	est.Upper = math.Max(est.Upper, value)
+	return est
+}
+
+// finiteQuantile returns the value at a rank among the finite values of d,
+// clamped to the range of values added to d.
+func (d *TDigest) finiteQuantile(rank float64) float64 {
+	v := d.quantile(rank / float64(d.countTotal))
+	return math.Max(d.min, math.Min(d.max, v))
+}
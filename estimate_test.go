@@ -0,0 +1,132 @@
+package tdigest
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestEstimateQuantileSingletons(t *testing.T) {
+	d := tdFromMeans([]float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10})
+
+	type testcase struct {
+		q         float64
+		want      float64
+		tailCount int64
+	}
+	testcases := []testcase{
+		{0, 1, 1},
+		{0.15, 2, 2},
+		{0.55, 6, 5},
+		{0.95, 10, 1},
+		{1, 10, 1},
+	}
+	for _, tc := range testcases {
+		est := d.EstimateQuantile(tc.q)
+		if !est.Exact {
+			t.Errorf("EstimateQuantile(%v) not exact: %+v", tc.q, est)
+		}
+		if est.Value != tc.want || est.Lower != tc.want || est.Upper != tc.want {
+			t.Errorf("EstimateQuantile(%v) wrong, have=%+v, want value=%v", tc.q, est, tc.want)
+		}
+		if est.TailCount != tc.tailCount {
+			t.Errorf("EstimateQuantile(%v) wrong tail count, have=%d, want=%d", tc.q, est.TailCount, tc.tailCount)
+		}
+	}
+}
+
+func TestEstimateQuantileLinearSingletons(t *testing.T) {
+	// Linear interpolation places quantiles between the samples of
+	// neighbouring singletons, so those estimates are not exact.
+	d := tdFromMeans([]float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10})
+	d.interpolation = Linear
+
+	type testcase struct {
+		q     float64
+		want  float64
+		exact bool
+	}
+	testcases := []testcase{
+		{0, 1, true},
+		{0.15, 2.35, false},
+		{0.5, 5.5, false},
+		{1, 10, true},
+	}
+	for _, tc := range testcases {
+		est := d.EstimateQuantile(tc.q)
+		if have := d.Quantile(tc.q); math.Abs(have-tc.want) > 1e-9 {
+			t.Fatalf("Quantile(%v) wrong, have=%v, want=%v", tc.q, have, tc.want)
+		}
+		if est.Value != d.Quantile(tc.q) {
+			t.Errorf("EstimateQuantile(%v) value differs from Quantile, have=%v, want=%v", tc.q, est.Value, d.Quantile(tc.q))
+		}
+		if est.Exact != tc.exact {
+			t.Errorf("EstimateQuantile(%v) wrong exactness, have=%v, want=%v", tc.q, est.Exact, tc.exact)
+		}
+		if !(est.Lower <= est.Value && est.Value <= est.Upper) {
+			t.Errorf("EstimateQuantile(%v) value outside bounds: %+v", tc.q, est)
+		}
+	}
+}
+
+func TestEstimateQuantileInterpolated(t *testing.T) {
+	rand.Seed(rngSeed)
+	d := New()
+	const n = 100000
+	for i := 0; i < n; i++ {
+		d.Add(rand.NormFloat64(), 1)
+	}
+
+	for _, q := range []float64{0.001, 0.1, 0.5, 0.9, 0.999} {
+		est := d.EstimateQuantile(q)
+		if est.Exact {
+			t.Errorf("EstimateQuantile(%v) should not be exact: %+v", q, est)
+		}
+		if est.Value != d.Quantile(q) {
+			t.Errorf("EstimateQuantile(%v) value differs from Quantile, have=%v, want=%v", q, est.Value, d.Quantile(q))
+		}
+		if !(est.Lower < est.Value && est.Value < est.Upper) {
+			t.Errorf("EstimateQuantile(%v) value outside bounds: %+v", q, est)
+		}
+		tail := math.Min(q, 1-q) * n
+		if float64(est.TailCount) < tail || float64(est.TailCount) > tail+n/10 {
+			t.Errorf("EstimateQuantile(%v) wrong tail count %d, want a little over %v", q, est.TailCount, tail)
+		}
+	}
+}
+
+func TestEstimateQuantileEdgeCases(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		est := New().EstimateQuantile(0.5)
+		if !math.IsNaN(est.Value) || !math.IsNaN(est.Lower) || !math.IsNaN(est.Upper) || est.Exact {
+			t.Errorf("empty TDigest has wrong estimate %+v", est)
+		}
+	})
+	t.Run("identical values", func(t *testing.T) {
+		d := New()
+		for i := 0; i < 1000; i++ {
+			d.Add(5, 1)
+		}
+		est := d.EstimateQuantile(0.3)
+		want := QuantileEstimate{Value: 5, Lower: 5, Upper: 5, Exact: true}
+		est.TailCount = 0
+		if est != want {
+			t.Errorf("wrong estimate, have=%+v, want=%+v", est, want)
+		}
+	})
+	t.Run("infinite tail", func(t *testing.T) {
+		d := New(WithNonFinite())
+		for i := 0; i < 100; i++ {
+			d.Add(float64(i), 1)
+		}
+		d.Add(math.Inf(+1), 5)
+		est := d.EstimateQuantile(0.99)
+		want := QuantileEstimate{Value: math.Inf(+1), Lower: math.Inf(+1), Upper: math.Inf(+1), Exact: true, TailCount: 5}
+		if est != want {
+			t.Errorf("wrong estimate, have=%+v, want=%+v", est, want)
+		}
+		if est := d.EstimateQuantile(0.9); est.TailCount <= 5 || math.IsInf(est.Value, 0) {
+			t.Errorf("finite estimate should count infinite tail, have=%+v", est)
+		}
+	})
+}
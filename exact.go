@@ -0,0 +1,95 @@
+package tdigest
+
+import "sort"
+
+// WithExactThreshold makes a TDigest hold the values added to it exactly,
+// rather than approximating them with centroids, until it holds more than n
+// of them. Until then, Quantile and CDF give exact results, with Quantile
+// using the method set by WithQuantileMethod. Once the threshold is passed,
+// the values are compressed into centroids and the TDigest carries on as
+// usual.
+//
+// Merging approximate data into a TDigest, subtracting approximate data from
+// it, or compressing it, also ends exact mode. Reset starts it again.
+//
+// The threshold is not part of the serialized form of a TDigest, but whether
+// it is in exact mode is. A TDigest holding exact data which is unmarshaled
+// into a TDigest with a lower threshold stays exact until a value is added
+// to it.
+func WithExactThreshold(n int) Option {
+	return func(d *TDigest) {
+		d.exactThreshold = int64(n)
+		d.exact = n > 0
+	}
+}
+
+// A QuantileMethod is a definition of the quantile of a sample, used by
+// Quantile while a TDigest holds its values exactly. The methods are the nine
+// described by Hyndman and Fan in "Sample Quantiles in Statistical Packages",
+// and are numbered as they are there and in R's quantile function.
+type QuantileMethod int
+
+const (
+	// R1 is the inverse of the empirical distribution function, also known
+	// as the nearest-rank method.
+	R1 QuantileMethod = iota + 1
+	// R2 is like R1, but averages the two values either side of a
+	// discontinuity.
+	R2
+	// R3 is the observation closest to N·q, taking the even one in case of
+	// ties, as used by SAS.
+	R3
+	// R4 interpolates linearly within the empirical distribution function.
+	R4
+	// R5 interpolates linearly between the midpoints of the steps of the
+	// empirical distribution function.
+	R5
+	// R6 interpolates linearly with the quantile of the kth value at
+	// k/(N+1), as used by Minitab and SPSS.
+	R6
+	// R7 interpolates linearly with the quantile of the kth value at
+	// (k-1)/(N-1). It is the default in R, NumPy and Excel's PERCENTILE.INC,
+	// and the default here.
+	R7
+	// R8 interpolates linearly to give approximately median-unbiased
+	// estimates whatever the distribution, and is recommended by Hyndman and
+	// Fan.
+	R8
+	// R9 interpolates linearly to give approximately unbiased estimates for
+	// normally distributed data.
+	R9
+)
+
+// WithQuantileMethod sets the method which Quantile uses to compute exact
+// quantiles when a TDigest is in exact mode. See WithExactThreshold. The
+// default is R7.
+func WithQuantileMethod(m QuantileMethod) Option {
+	return func(d *TDigest) {
+		d.quantileMethod = m
+	}
+}
+
+// Exact reports whether d holds the values added to it exactly.
+func (d *TDigest) Exact() bool {
+	return d.exact
+}
+
+// addExact adds weight copies of val to d while it is in exact mode, in which
+// each of d's centroids holds all the copies of a single value.
+func (d *TDigest) addExact(val float64, weight int64) {
+	d.countTotal += weight
+	n := len(d.centroids)
+	idx := sort.Search(n, func(i int) bool { return d.centroids[i].mean >= val })
+	if idx < n && d.centroids[idx].mean == val {
+		d.centroids[idx].count += weight
+		d.addCumulative(idx, weight)
+	} else {
+		d.addNewCentroid(val, weight)
+	}
+
+	if d.countTotal > d.exactThreshold {
+		d.mergeCentroids(d.compression)
+		return
+	}
+	d.enforceMaxCentroids()
+}
@@ -0,0 +1,135 @@
+package tdigest
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestExactQuantileMethods(t *testing.T) {
+	values := []float64{2, 3, 5, 7, 11, 13, 17, 19, 23, 29}
+	qs := []float64{0, 0.1, 0.25, 0.5, 0.9, 1}
+	// Reference values computed with R's quantile function.
+	want := map[QuantileMethod][]float64{
+		R1: {2, 2, 5, 11, 23, 29},
+		R2: {2, 2.5, 5, 12, 26, 29},
+		R3: {2, 2, 3, 11, 23, 29},
+		R4: {2, 2, 4, 11, 23, 29},
+		R5: {2, 2.5, 5, 12, 26, 29},
+		R6: {2, 2.1, 4.5, 12, 28.4, 29},
+		R7: {2, 2.9, 5.5, 12, 23.6, 29},
+		R8: {2, 2.3666666667, 4.8333333333, 12, 26.8, 29},
+		R9: {2, 2.4, 4.875, 12, 26.6, 29},
+	}
+
+	for method := R1; method <= R9; method++ {
+		d := New(WithExactThreshold(100), WithQuantileMethod(method))
+		for _, i := range rand.Perm(len(values)) {
+			d.Add(values[i], 1)
+		}
+		if !d.Exact() {
+			t.Fatalf("R%d: TDigest left exact mode early", method)
+		}
+		for i, q := range qs {
+			if have := d.Quantile(q); math.Abs(have-want[method][i]) > 1e-9 {
+				t.Errorf("R%d: Quantile(%v) wrong, have=%v, want=%v", method, q, have, want[method][i])
+			}
+		}
+	}
+}
+
+func TestExactQuantileWeights(t *testing.T) {
+	// Weighted values should act just like repeated ones.
+	weighted := New(WithExactThreshold(100))
+	repeated := New(WithExactThreshold(100))
+	for i, w := range []int{3, 1, 4, 1, 5} {
+		weighted.Add(float64(i), w)
+		for j := 0; j < w; j++ {
+			repeated.Add(float64(i), 1)
+		}
+	}
+	if n := len(weighted.centroids); n != 5 {
+		t.Errorf("identical values should share a centroid, have %d centroids", n)
+	}
+	for q := 0.0; q <= 1; q += 0.05 {
+		if have, want := weighted.Quantile(q), repeated.Quantile(q); have != want {
+			t.Errorf("Quantile(%v) wrong, have=%v, want=%v", q, have, want)
+		}
+	}
+	if have, want := weighted.Quantile(0.5), 2.0; have != want {
+		t.Errorf("weighted median wrong, have=%v, want=%v", have, want)
+	}
+}
+
+func TestExactCDF(t *testing.T) {
+	d := New(WithExactThreshold(100))
+	for _, v := range []float64{1, 2, 2, 3, 10} {
+		d.Add(v, 1)
+	}
+	type testcase struct {
+		x, want float64
+	}
+	for _, tc := range []testcase{{0, 0}, {1, 0.2}, {1.5, 0.2}, {2, 0.6}, {9.9, 0.8}, {10, 1}, {11, 1}} {
+		if have := d.CDF(tc.x); have != tc.want {
+			t.Errorf("CDF(%v) wrong, have=%v, want=%v", tc.x, have, tc.want)
+		}
+	}
+}
+
+func TestExactThreshold(t *testing.T) {
+	d := New(WithExactThreshold(100))
+	for i := 0; i < 100; i++ {
+		d.Add(float64(i), 1)
+	}
+	if !d.Exact() {
+		t.Fatalf("TDigest left exact mode at its threshold")
+	}
+	if have, want := d.Quantile(0.5), 49.5; have != want {
+		t.Errorf("exact median wrong, have=%v, want=%v", have, want)
+	}
+	if est := d.EstimateQuantile(0.5); !est.Exact || est.Value != 49.5 {
+		t.Errorf("exact estimate wrong, have=%+v", est)
+	}
+
+	d.Add(100, 1)
+	if d.Exact() {
+		t.Fatalf("TDigest stayed in exact mode beyond its threshold")
+	}
+	if have := d.Count(); have != 101 {
+		t.Errorf("leaving exact mode lost values, count=%d", have)
+	}
+	if have := d.Quantile(0.5); math.Abs(have-50) > 2 {
+		t.Errorf("approximate median wrong, have=%v, want about 50", have)
+	}
+
+	d.Reset()
+	if !d.Exact() {
+		t.Errorf("Reset did not restart exact mode")
+	}
+}
+
+func TestExactMerge(t *testing.T) {
+	a := New(WithExactThreshold(100))
+	b := New(WithExactThreshold(100))
+	for i := 0; i < 10; i++ {
+		a.Add(float64(i), 1)
+		b.Add(float64(i+10), 1)
+	}
+	a.MergeInto(b)
+	if !b.Exact() {
+		t.Fatalf("merging exact TDigests left exact mode")
+	}
+	if have, want := b.Quantile(0.5), 9.5; have != want {
+		t.Errorf("merged median wrong, have=%v, want=%v", have, want)
+	}
+
+	approximate := New()
+	approximate.Add(1, 1)
+	approximate.MergeInto(b)
+	if b.Exact() {
+		t.Errorf("merging approximate data stayed in exact mode")
+	}
+	if have := b.Count(); have != 21 {
+		t.Errorf("merge lost values, count=%d", have)
+	}
+}
@@ -0,0 +1,76 @@
+package tdigest
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"sync"
+
+	"github.com/spenczar/tdigest/v2/internal/percentile"
+)
+
+// A Var publishes a summary of a TDigest through the expvar package. It
+// implements expvar.Var: its String method returns a JSON object holding the
+// TDigest's count, min, max and configured quantiles, which are named as
+// percentiles like "p99.9". For example:
+//
+//	var (
+//		mu      sync.Mutex
+//		latency = tdigest.New()
+//	)
+//	expvar.Publish("latency", tdigest.NewVar(latency, &mu, 0.5, 0.99, 0.999))
+//
+// An empty TDigest is summarized with just its count.
+type Var struct {
+	d         *TDigest
+	mu        sync.Locker
+	quantiles []float64
+}
+
+// NewVar returns a Var summarizing d with the given quantiles.
+//
+// TDigests are not safe for concurrent use, even just for queries, so mu is
+// held while the summary is computed. If d is used anywhere else while the Var
+// may be read, mu must be the lock which guards that use, and it must be an
+// exclusive lock rather than the read lock of a sync.RWMutex. If nothing else
+// uses d, mu may be nil, and the Var uses a lock of its own.
+func NewVar(d *TDigest, mu sync.Locker, quantiles ...float64) *Var {
+	if mu == nil {
+		mu = new(sync.Mutex)
+	}
+	return &Var{
+		d:         d,
+		mu:        mu,
+		quantiles: quantiles,
+	}
+}
+
+// String returns the JSON summary of v's TDigest.
+func (v *Var) String() string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	buf := []byte(`{"count":`)
+	buf = strconv.AppendInt(buf, v.d.Count(), 10)
+	if v.d.Count() > 0 {
+		buf = appendJSONField(buf, "min", v.d.Min())
+		buf = appendJSONField(buf, "max", v.d.Max())
+		for _, q := range v.quantiles {
+			buf = appendJSONField(buf, percentile.Name(q), v.d.Quantile(q))
+		}
+	}
+	buf = append(buf, '}')
+	return string(buf)
+}
+
+func appendJSONField(buf []byte, name string, val float64) []byte {
+	buf = append(buf, ',')
+	key, _ := json.Marshal(name)
+	buf = append(buf, key...)
+	buf = append(buf, ':')
+	if math.IsNaN(val) || math.IsInf(val, 0) {
+		// JSON has no representation for these.
+		return append(buf, "null"...)
+	}
+	return strconv.AppendFloat(buf, val, 'g', -1, 64)
+}
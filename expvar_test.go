@@ -0,0 +1,85 @@
+package tdigest
+
+import (
+	"encoding/json"
+	"expvar"
+	"sync"
+	"testing"
+)
+
+func TestVar(t *testing.T) {
+	var (
+		mu sync.Mutex
+		d  = New()
+		v  = NewVar(d, &mu, 0.5, 0.999)
+	)
+	expvar.Publish("tdigest_test_var", v)
+
+	if have, want := expvar.Get("tdigest_test_var").String(), `{"count":0}`; have != want {
+		t.Errorf("Var.String wrong for empty TDigest, have=%s, want=%s", have, want)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			mu.Lock()
+			d.Add(float64(i), 1)
+			mu.Unlock()
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		var summary map[string]float64
+		if err := json.Unmarshal([]byte(v.String()), &summary); err != nil {
+			t.Fatalf("Var.String produced invalid JSON %s: %v", v.String(), err)
+		}
+	}
+	wg.Wait()
+
+	var summary map[string]float64
+	if err := json.Unmarshal([]byte(v.String()), &summary); err != nil {
+		t.Fatalf("Var.String produced invalid JSON %s: %v", v.String(), err)
+	}
+	if summary["count"] != 1000 || summary["min"] != 0 || summary["max"] != 999 {
+		t.Errorf("Var.String wrong count, min or max: %s", v.String())
+	}
+	if p50 := summary["p50"]; p50 < 450 || p50 > 550 {
+		t.Errorf("Var.String wrong p50, have=%v, want about 500: %s", p50, v.String())
+	}
+	if _, ok := summary["p99.9"]; !ok {
+		t.Errorf("Var.String missing p99.9: %s", v.String())
+	}
+}
+
+func TestVarNilLocker(t *testing.T) {
+	// Reading a Var caches results inside its TDigest, so even with nothing
+	// else using the TDigest, concurrent reads must be serialized. Start the
+	// readers together on TDigests with nothing cached, so that the race
+	// detector would see them overlap.
+	for i := 0; i < 20; i++ {
+		d := New()
+		for j := 0; j < 1000; j++ {
+			d.Add(float64(j), 1)
+		}
+		// A new smallest value gets a centroid of its own, which leaves
+		// d with nothing cached.
+		d.Add(-1, 1)
+		v := NewVar(d, nil, 0.5)
+
+		var (
+			wg    sync.WaitGroup
+			start = make(chan struct{})
+		)
+		for j := 0; j < 4; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				_ = v.String()
+			}()
+		}
+		close(start)
+		wg.Wait()
+	}
+}
@@ -5,6 +5,8 @@ package tdigest
 
 import (
 	"bytes"
+	"encoding/binary"
+	"reflect"
 	"testing"
 )
 
@@ -71,10 +73,22 @@ func FuzzRoundTrip(f *testing.F) {
 			t.Fatalf("marshal error for valid data: %v", err)
 		}
 
-		if !bytes.HasPrefix(data, remarshaled) {
+		// Older versions of the encoding are rewritten in the latest one,
+		// so only the latest can be expected to round-trip byte for byte.
+		version := int32(binary.LittleEndian.Uint32(data[2:]))
+		if version == encodingVersion && !bytes.HasPrefix(data, remarshaled) {
 			t.Logf("tdigest: %s", v.debugStr())
 			t.Fatal("remarshaling does not round-trip")
 		}
+		redecoded := new(TDigest)
+		if err := redecoded.UnmarshalBinary(remarshaled); err != nil {
+			t.Fatalf("unmarshal error for remarshaled data: %v", err)
+		}
+		if !reflect.DeepEqual(v, redecoded) {
+			t.Logf("tdigest: %s", v.debugStr())
+			t.Logf("redecoded: %s", redecoded.debugStr())
+			t.Fatal("remarshaled data decodes differently")
+		}
 
 		for q := float64(0.1); q <= 1.0; q += 0.05 {
 			prev, this := v.Quantile(q-0.1), v.Quantile(q)
@@ -1,3 +1,3 @@
 module github.com/spenczar/tdigest/v2
 
-go 1.17
+go 1.18
@@ -0,0 +1,194 @@
+// Package accuracy measures how closely a streaming quantile estimator, like a
+// TDigest, matches the exact quantiles of a dataset.
+//
+// It doesn't depend on the tdigest package, so it can be used from that
+// package's own tests as well as from standalone programs.
+package accuracy
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// A Source produces an endless stream of values.
+type Source interface {
+	Next() float64
+}
+
+// A Distribution describes a family of Sources.
+type Distribution struct {
+	Name string
+	// New returns a Source for the distribution. Sources created with the
+	// same seed produce the same values.
+	New func(seed int64) Source
+}
+
+// Distributions lists the distributions used to measure accuracy.
+var Distributions = []Distribution{
+	{"ordered", func(int64) Source { return &orderedValues{} }},
+	{"uniform", func(seed int64) Source { return &uniformValues{rand.New(rand.NewSource(seed))} }},
+	{"normal", func(seed int64) Source { return &normalValues{rand.New(rand.NewSource(seed))} }},
+	{"zipfian", newZipfValues},
+	{"bimodal", func(seed int64) Source { return &bimodalValues{rand.New(rand.NewSource(seed))} }},
+	{"pareto", func(seed int64) Source { return &paretoValues{rand.New(rand.NewSource(seed))} }},
+}
+
+type orderedValues struct {
+	last float64
+}
+
+func (ov *orderedValues) Next() float64 {
+	ov.last += 1
+	return ov.last
+}
+
+type uniformValues struct {
+	r *rand.Rand
+}
+
+func (uv *uniformValues) Next() float64 {
+	return uv.r.Float64()
+}
+
+type normalValues struct {
+	r *rand.Rand
+}
+
+func (nv *normalValues) Next() float64 {
+	return nv.r.NormFloat64()
+}
+
+type zipfValues struct {
+	z *rand.Zipf
+}
+
+func newZipfValues(seed int64) Source {
+	r := rand.New(rand.NewSource(seed))
+	return &zipfValues{rand.NewZipf(r, 1.2, 1, 1024*1024)}
+}
+
+func (zv *zipfValues) Next() float64 {
+	return float64(zv.z.Uint64())
+}
+
+// bimodalValues mixes two normal distributions of different widths, with
+// most of the weight in the narrower one.
+type bimodalValues struct {
+	r *rand.Rand
+}
+
+func (bv *bimodalValues) Next() float64 {
+	if bv.r.Float64() < 0.7 {
+		return bv.r.NormFloat64()
+	}
+	return 10 + 3*bv.r.NormFloat64()
+}
+
+// paretoValues is a heavy-tailed Pareto distribution with shape 1.5 and
+// scale 1, so it has a finite mean but an infinite variance.
+type paretoValues struct {
+	r *rand.Rand
+}
+
+func (pv *paretoValues) Next() float64 {
+	return math.Pow(1-pv.r.Float64(), -1/1.5)
+}
+
+// Values returns the next n values from src.
+func Values(src Source, n int) []float64 {
+	vals := make([]float64, n)
+	for i := range vals {
+		vals[i] = src.Next()
+	}
+	return vals
+}
+
+// An Estimator computes approximate quantiles of the values added to it.
+type Estimator interface {
+	Add(val float64, weight int)
+	Quantile(q float64) float64
+}
+
+// A Result describes the error of an Estimator at one quantile.
+type Result struct {
+	Q        float64
+	Exact    float64 // the exact quantile of the data
+	Estimate float64 // the Estimator's approximation of it
+
+	// AbsError is the absolute difference between Estimate and Exact.
+	AbsError float64
+	// RankError is the distance, as a fraction of the data, between Q and
+	// the rank of Estimate in the data. When Estimate is equal to several
+	// values in the data, it occupies a range of ranks, and RankError is
+	// zero if Q falls anywhere in that range.
+	RankError float64
+}
+
+// Measure adds vals to e with a weight of 1, and then compares e's estimate of
+// each quantile in qs to the exact value.
+func Measure(e Estimator, vals []float64, qs []float64) []Result {
+	for _, v := range vals {
+		e.Add(v, 1)
+	}
+	sorted := make([]float64, len(vals))
+	copy(sorted, vals)
+	sort.Float64s(sorted)
+
+	results := make([]Result, len(qs))
+	for i, q := range qs {
+		r := Result{
+			Q:        q,
+			Exact:    Quantile(sorted, q),
+			Estimate: e.Quantile(q),
+		}
+		r.AbsError = math.Abs(r.Estimate - r.Exact)
+		r.RankError = rankError(sorted, q, r.Estimate)
+		results[i] = r
+	}
+	return results
+}
+
+// Quantile returns the exact qth quantile of sorted, which must be sorted in
+// increasing order, by interpolating linearly between the closest ranks. This
+// matches the default behavior of numpy's percentile function.
+func Quantile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	h := q * float64(len(sorted)-1)
+	lo := int(math.Floor(h))
+	if lo < 0 {
+		return sorted[0]
+	}
+	if lo >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lo] + (h-float64(lo))*(sorted[lo+1]-sorted[lo])
+}
+
+func rankError(sorted []float64, q, estimate float64) float64 {
+	n := float64(len(sorted))
+	below := sort.SearchFloat64s(sorted, estimate)
+	atOrBelow := sort.Search(len(sorted), func(i int) bool { return sorted[i] > estimate })
+	lo, hi := float64(below)/n, float64(atOrBelow)/n
+	switch {
+	case q < lo:
+		return lo - q
+	case q > hi:
+		return q - hi
+	default:
+		return 0
+	}
+}
+
+// MaxRankError returns the largest RankError among results.
+func MaxRankError(results []Result) float64 {
+	var max float64
+	for _, r := range results {
+		if r.RankError > max {
+			max = r.RankError
+		}
+	}
+	return max
+}
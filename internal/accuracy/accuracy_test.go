@@ -0,0 +1,79 @@
+package accuracy
+
+import (
+	"math"
+	"sort"
+	"testing"
+)
+
+// exactEstimator holds all of its values, so it should have no error.
+type exactEstimator struct {
+	vals []float64
+}
+
+func (e *exactEstimator) Add(val float64, weight int) {
+	for i := 0; i < weight; i++ {
+		e.vals = append(e.vals, val)
+	}
+}
+
+func (e *exactEstimator) Quantile(q float64) float64 {
+	sort.Float64s(e.vals)
+	return Quantile(e.vals, q)
+}
+
+func TestMeasureExact(t *testing.T) {
+	qs := []float64{0, 0.001, 0.01, 0.5, 0.99, 0.999, 1}
+	for _, dist := range Distributions {
+		results := Measure(&exactEstimator{}, Values(dist.New(1), 10000), qs)
+		for _, r := range results {
+			if r.AbsError != 0 || r.RankError != 0 {
+				t.Errorf("%s: exact estimator has error at q=%v: %+v", dist.Name, r.Q, r)
+			}
+		}
+	}
+}
+
+func TestQuantile(t *testing.T) {
+	type testcase struct {
+		q    float64
+		want float64
+	}
+	vals := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	// reference values from numpy.percentile(range(1, 11), q*100)
+	testcases := []testcase{
+		{0, 1},
+		{0.1, 1.9},
+		{0.25, 3.25},
+		{0.5, 5.5},
+		{0.9, 9.1},
+		{1, 10},
+	}
+	for i, tc := range testcases {
+		if have := Quantile(vals, tc.q); math.Abs(have-tc.want) > 1e-9 {
+			t.Errorf("Quantile wrong test=%d, have=%v, want=%v", i, have, tc.want)
+		}
+	}
+}
+
+func TestRankError(t *testing.T) {
+	type testcase struct {
+		q, estimate float64
+		want        float64
+	}
+	sorted := []float64{1, 2, 2, 2, 3}
+	testcases := []testcase{
+		{0.1, 1, 0},
+		{0.5, 2, 0},
+		{0.8, 2, 0},
+		{0.9, 2, 0.1},
+		{0.1, 2, 0.1},
+		{0.5, 2.5, 0.3},
+		{1, 3, 0},
+	}
+	for i, tc := range testcases {
+		if have := rankError(sorted, tc.q, tc.estimate); math.Abs(have-tc.want) > 1e-9 {
+			t.Errorf("rankError wrong test=%d, have=%v, want=%v", i, have, tc.want)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+// Package percentile names quantiles as percentiles, like "p99.9" for the
+// 0.999 quantile. It is shared by the tdigest package, which uses the names in
+// the summaries it publishes, and the tdigest command, which prints them.
+package percentile
+
+import (
+	"math"
+	"strconv"
+)
+
+// Name names the qth quantile as a percentile, like "p99.9".
+func Name(q float64) string {
+	// Round away floating point noise, like 0.999*100 = 99.89999999999999.
+	pct := math.Round(q*100*1e9) / 1e9
+	return "p" + strconv.FormatFloat(pct, 'f', -1, 64)
+}
@@ -0,0 +1,24 @@
+package percentile
+
+import "testing"
+
+func TestName(t *testing.T) {
+	testcases := []struct {
+		q    float64
+		want string
+	}{
+		{0, "p0"},
+		{0.5, "p50"},
+		{0.9, "p90"},
+		{0.99, "p99"},
+		{0.999, "p99.9"},
+		{0.9999, "p99.99"},
+		{0.001, "p0.1"},
+		{1, "p100"},
+	}
+	for _, tc := range testcases {
+		if have := Name(tc.q); have != tc.want {
+			t.Errorf("Name(%v) wrong, have=%q, want=%q", tc.q, have, tc.want)
+		}
+	}
+}
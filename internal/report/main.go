@@ -0,0 +1,145 @@
+// Command report runs experiments measuring the compression and accuracy of
+// t-digests on a range of data distributions, and writes the results as SVG
+// charts.
+//
+// Usage:
+//
+//	go run ./internal/report [-out dir] [-compression c]
+//
+// It writes compression.svg, showing the compression ratio (the number of
+// data points divided by the number of centroids) for increasing numbers of
+// points, and quantile_error.svg, showing the rank error of the estimates of
+// several quantiles. The charts in the repository's docs directory are
+// generated with:
+//
+//	go run ./internal/report -out docs
+//
+// All random data is generated from fixed seeds, so the charts only change
+// when the algorithm does.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/spenczar/tdigest/v2"
+	"github.com/spenczar/tdigest/v2/internal/accuracy"
+)
+
+const seed = 1234567
+
+var (
+	colors = map[string]string{
+		"ordered": "#ff7f0e",
+		"uniform": "#2ca02c",
+		"normal":  "#1f77b4",
+		"zipfian": "#d62728",
+		"bimodal": "#9467bd",
+		"pareto":  "#8c564b",
+	}
+
+	compressionSizes = []int{1000, 10000, 20000, 100000, 1000000}
+	errorQuantiles   = []float64{0.001, 0.01, 0.1, 0.25, 0.5, 0.75, 0.9, 0.99, 0.999}
+	errorSize        = 100000
+)
+
+func main() {
+	var (
+		out         = flag.String("out", ".", "directory to write charts to")
+		compression = flag.Float64("compression", 100, "compression level of the t-digests")
+	)
+	flag.Parse()
+	log.SetFlags(0)
+	log.SetPrefix("report: ")
+
+	charts := map[string]*chart{
+		"compression.svg":    compressionChart(*compression),
+		"quantile_error.svg": errorChart(*compression),
+	}
+	for name, c := range charts {
+		if err := writeChart(filepath.Join(*out, name), c); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func writeChart(name string, c *chart) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := c.writeSVG(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// compressionChart measures the compression ratio of t-digests holding
+// increasing numbers of points from each distribution.
+func compressionChart(compression float64) *chart {
+	c := &chart{
+		title:  "t-digest compression ratio by data distribution and # of points",
+		xLabel: "# Data Points",
+		yLabel: "Compression Ratio",
+	}
+	for _, n := range compressionSizes {
+		c.categories = append(c.categories, sizeName(n))
+	}
+	for _, dist := range accuracy.Distributions {
+		s := series{name: dist.Name, color: colors[dist.Name]}
+		for _, n := range compressionSizes {
+			rand.Seed(seed)
+			d := tdigest.NewWithCompression(compression)
+			src := dist.New(seed)
+			for i := 0; i < n; i++ {
+				d.Add(src.Next(), 1)
+			}
+			s.values = append(s.values, float64(n)/float64(len(d.Centroids())))
+		}
+		c.series = append(c.series, s)
+	}
+	return c
+}
+
+// errorChart measures the rank error of t-digest quantile estimates for each
+// distribution.
+func errorChart(compression float64) *chart {
+	c := &chart{
+		title:  fmt.Sprintf("t-digest quantile rank error, %s points", sizeName(errorSize)),
+		xLabel: "Quantile",
+		yLabel: "Rank Error (%)",
+		lines:  true,
+	}
+	for _, q := range errorQuantiles {
+		c.categories = append(c.categories, strconv.FormatFloat(q, 'f', -1, 64))
+	}
+	for _, dist := range accuracy.Distributions {
+		rand.Seed(seed)
+		vals := accuracy.Values(dist.New(seed), errorSize)
+		results := accuracy.Measure(tdigest.NewWithCompression(compression), vals, errorQuantiles)
+		s := series{name: dist.Name, color: colors[dist.Name]}
+		for _, r := range results {
+			s.values = append(s.values, r.RankError*100)
+		}
+		c.series = append(c.series, s)
+	}
+	return c
+}
+
+// sizeName abbreviates n, like "10k" or "1m".
+func sizeName(n int) string {
+	switch {
+	case n >= 1000000 && n%1000000 == 0:
+		return strconv.Itoa(n/1000000) + "m"
+	case n >= 1000 && n%1000 == 0:
+		return strconv.Itoa(n/1000) + "k"
+	default:
+		return strconv.Itoa(n)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"math"
+)
+
+// A chart plots one or more series of values against a shared set of
+// categories on the x axis, either as groups of bars or as lines.
+type chart struct {
+	title      string
+	xLabel     string
+	yLabel     string
+	categories []string
+	series     []series
+	lines      bool // draw lines rather than bars
+}
+
+type series struct {
+	name   string
+	color  string
+	values []float64 // one per category
+}
+
+const (
+	chartWidth   = 900
+	chartHeight  = 560
+	marginLeft   = 90
+	marginRight  = 160
+	marginTop    = 70
+	marginBottom = 80
+	plotWidth    = chartWidth - marginLeft - marginRight
+	plotHeight   = chartHeight - marginTop - marginBottom
+	fontFamily   = "Helvetica, Arial, sans-serif"
+)
+
+// writeSVG renders c as a standalone SVG document.
+func (c *chart) writeSVG(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	p := func(format string, args ...interface{}) {
+		fmt.Fprintf(bw, format, args...)
+		bw.WriteByte('\n')
+	}
+
+	var top float64
+	for _, s := range c.series {
+		for _, v := range s.values {
+			top = math.Max(top, v)
+		}
+	}
+	step := niceStep(top / 6)
+	top = math.Max(step, math.Ceil(top/step)*step)
+	y := func(v float64) float64 {
+		return marginTop + plotHeight*(1-v/top)
+	}
+	catWidth := float64(plotWidth) / float64(len(c.categories))
+
+	p(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="%s">`,
+		chartWidth, chartHeight, chartWidth, chartHeight, fontFamily)
+	p(`<rect width="100%%" height="100%%" fill="white"/>`)
+	p(`<text x="%d" y="%d" font-size="22" text-anchor="middle" fill="#444">%s</text>`,
+		marginLeft+plotWidth/2, marginTop/2, html.EscapeString(c.title))
+
+	// y axis gridlines and labels
+	for v := 0.0; v <= top+step/2; v += step {
+		p(`<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#e5e5e5"/>`, marginLeft, y(v), marginLeft+plotWidth, y(v))
+		p(`<text x="%d" y="%.1f" font-size="13" text-anchor="end" fill="#444">%s</text>`,
+			marginLeft-8, y(v)+4, formatTick(v))
+	}
+	p(`<text transform="translate(%d %d) rotate(-90)" font-size="16" text-anchor="middle" fill="#444">%s</text>`,
+		24, marginTop+plotHeight/2, html.EscapeString(c.yLabel))
+
+	// x axis and category labels
+	p(`<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#444"/>`,
+		marginLeft, marginTop+plotHeight, marginLeft+plotWidth, marginTop+plotHeight)
+	for i, cat := range c.categories {
+		p(`<text x="%.1f" y="%d" font-size="13" text-anchor="middle" fill="#444">%s</text>`,
+			marginLeft+catWidth*(float64(i)+0.5), marginTop+plotHeight+20, html.EscapeString(cat))
+	}
+	p(`<text x="%d" y="%d" font-size="16" text-anchor="middle" fill="#444">%s</text>`,
+		marginLeft+plotWidth/2, chartHeight-20, html.EscapeString(c.xLabel))
+
+	// data
+	barWidth := catWidth * 0.8 / float64(len(c.series))
+	for si, s := range c.series {
+		if c.lines {
+			var points string
+			for i, v := range s.values {
+				points += fmt.Sprintf("%.1f,%.1f ", marginLeft+catWidth*(float64(i)+0.5), y(v))
+			}
+			p(`<polyline points="%s" fill="none" stroke="%s" stroke-width="2"/>`, points, s.color)
+			for i, v := range s.values {
+				p(`<circle cx="%.1f" cy="%.1f" r="3" fill="%s"/>`, marginLeft+catWidth*(float64(i)+0.5), y(v), s.color)
+			}
+			continue
+		}
+		for i, v := range s.values {
+			x := marginLeft + catWidth*(float64(i)+0.1) + barWidth*float64(si)
+			p(`<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"/>`,
+				x, y(v), barWidth, y(0)-y(v), s.color)
+		}
+	}
+
+	// legend
+	for i, s := range c.series {
+		ly := marginTop + 10 + 26*i
+		p(`<rect x="%d" y="%d" width="16" height="16" fill="%s"/>`, chartWidth-marginRight+24, ly, s.color)
+		p(`<text x="%d" y="%d" font-size="15" fill="#444">%s</text>`,
+			chartWidth-marginRight+50, ly+13, html.EscapeString(s.name))
+	}
+
+	p(`</svg>`)
+	return bw.Flush()
+}
+
+// niceStep rounds x up to a step size of 1, 2 or 5 times a power of ten.
+func niceStep(x float64) float64 {
+	if x <= 0 {
+		return 1
+	}
+	mag := math.Pow(10, math.Floor(math.Log10(x)))
+	for _, m := range []float64{1, 2, 5} {
+		if m*mag >= x {
+			return m * mag
+		}
+	}
+	return 10 * mag
+}
+
+func formatTick(v float64) string {
+	// Round away floating point noise from summing up steps.
+	return fmt.Sprintf("%g", math.Round(v*1e9)/1e9)
+}
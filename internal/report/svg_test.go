@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"testing"
+)
+
+func TestWriteSVG(t *testing.T) {
+	for _, lines := range []bool{false, true} {
+		c := &chart{
+			title:      "a <title>",
+			categories: []string{"a", "b", "c"},
+			series: []series{
+				{name: "one", color: "red", values: []float64{1, 2, 3}},
+				{name: "two", color: "blue", values: []float64{0, 0.5, 10}},
+			},
+			lines: lines,
+		}
+		var buf bytes.Buffer
+		if err := c.writeSVG(&buf); err != nil {
+			t.Fatalf("writeSVG err: %v", err)
+		}
+		dec := xml.NewDecoder(&buf)
+		for {
+			_, err := dec.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("writeSVG produced invalid XML (lines=%v): %v", lines, err)
+			}
+		}
+	}
+}
+
+func TestNiceStep(t *testing.T) {
+	type testcase struct {
+		in, want float64
+	}
+	testcases := []testcase{
+		{0, 1},
+		{0.03, 0.05},
+		{1, 1},
+		{1.5, 2},
+		{3, 5},
+		{7, 10},
+		{120, 200},
+	}
+	for i, tc := range testcases {
+		if have := niceStep(tc.in); have != tc.want {
+			t.Errorf("niceStep wrong test=%d, have=%v, want=%v", i, have, tc.want)
+		}
+	}
+}
+
+func TestSizeName(t *testing.T) {
+	type testcase struct {
+		in   int
+		want string
+	}
+	testcases := []testcase{
+		{500, "500"},
+		{1000, "1k"},
+		{20000, "20k"},
+		{1000000, "1m"},
+		{1500000, "1500k"},
+	}
+	for i, tc := range testcases {
+		if have := sizeName(tc.in); have != tc.want {
+			t.Errorf("sizeName wrong test=%d, have=%v, want=%v", i, have, tc.want)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package tdigest
+
+import (
+	"math"
+	"sort"
+)
+
+// An Interpolation is a way of estimating quantiles from a TDigest's
+// centroids. It does not apply while a TDigest is in exact mode, in which
+// quantiles are computed with a QuantileMethod instead.
+type Interpolation int
+
+const (
+	// Midpoint treats the mean of each centroid as lying at the middle of
+	// the range of ranks it covers, and interpolates linearly between the
+	// midpoints of adjacent centroids. A centroid with a count of 1 is a
+	// single sample, so it is treated as an exact point at the middle of the
+	// unit of rank it covers. The minimum and maximum are known exactly, and
+	// are single samples in the first and last units of rank, which it
+	// interpolates towards beyond the midpoints of the outermost centroids,
+	// unless those are singletons already. When each centroid holds a
+	// single value, it gives the same results as R5. It is the default.
+	Midpoint Interpolation = iota
+	// Linear treats each centroid as count copies of its mean, and
+	// interpolates linearly between them, with the kth of N copies at
+	// quantile (k-1)/(N-1). It is method R7 of Hyndman and Fan, and gives
+	// the same results as NumPy's default and SQL's percentile_cont when
+	// each centroid holds a single value.
+	Linear
+	// NearestRank treats each centroid as count copies of its mean, and
+	// picks the first copy at or above the quantile's rank: the ceil(q·N)th
+	// of N copies. It is method R1 of Hyndman and Fan, and gives the same
+	// results as SQL's percentile_disc when each centroid holds a single
+	// value. The results step from one centroid mean to the next, and are
+	// never interpolated.
+	NearestRank
+)
+
+// WithInterpolation sets the way a TDigest estimates quantiles from its
+// centroids. CDF is adjusted to be the inverse of Quantile under each
+// Interpolation. The default is Midpoint.
+func WithInterpolation(i Interpolation) Option {
+	return func(d *TDigest) {
+		d.interpolation = i
+	}
+}
+
+// sampleQuantile computes the qth quantile of d's centroids, which must not
+// be empty, using method. Each centroid is treated as count copies of its
+// mean, so the quantile is exact if d is in exact mode.
+func (d *TDigest) sampleQuantile(q float64, method QuantileMethod) float64 {
+	if q < 0 {
+		q = 0
+	} else if q > 1 {
+		q = 1
+	}
+	// This follows R's implementation: find the index j of the value at or
+	// below the quantile, and how far h the quantile is along the way to the
+	// next value.
+	const fuzz = 4 * 2.220446049250313e-16
+	var (
+		n    = float64(d.countTotal)
+		nppm float64
+		j    float64
+		h    float64
+	)
+	if method < R1 || method > R9 {
+		method = R7
+	}
+	switch method {
+	case R1, R2, R3:
+		nppm = n * q
+		if method == R3 {
+			nppm -= 0.5
+		}
+		j = math.Floor(nppm + fuzz)
+		switch method {
+		case R1:
+			if nppm > j {
+				h = 1
+			}
+		case R2:
+			h = 0.5
+			if nppm > j {
+				h = 1
+			}
+		case R3:
+			if nppm != j || math.Mod(j, 2) != 0 {
+				h = 1
+			}
+		}
+	default:
+		var a, b float64
+		switch method {
+		case R4:
+			a, b = 0, 1
+		case R5:
+			a, b = 0.5, 0.5
+		case R6:
+			a, b = 0, 0
+		case R7:
+			a, b = 1, 1
+		case R8:
+			a, b = 1.0/3, 1.0/3
+		case R9:
+			a, b = 3.0/8, 3.0/8
+		}
+		nppm = a + q*(n+1-a-b)
+		j = math.Floor(nppm + fuzz)
+		h = nppm - j
+		if math.Abs(h) < fuzz {
+			h = 0
+		}
+	}
+
+	lo := d.orderStatistic(j)
+	switch {
+	case h == 0:
+		return lo
+	case h == 1:
+		return d.orderStatistic(j + 1)
+	}
+	return (1-h)*lo + h*d.orderStatistic(j+1)
+}
+
+// orderStatistic returns the kth smallest value in d's centroids, treating
+// each as count copies of its mean, and counting from 1. k is clamped into
+// the range of values in d.
+func (d *TDigest) orderStatistic(k float64) float64 {
+	if k < 1 {
+		k = 1
+	} else if k > float64(d.countTotal) {
+		k = float64(d.countTotal)
+	}
+	idx, _ := d.searchCumulative(k)
+	if idx == len(d.centroids) {
+		// Only possible if the centroids have no weight.
+		idx--
+	}
+	return d.centroids[idx].mean
+}
+
+// sampleCDF computes the fraction of the values in d's centroids, which must
+// not be empty, which are less than or equal to x, treating each centroid as
+// count copies of its mean.
+func (d *TDigest) sampleCDF(x float64) float64 {
+	idx := sort.Search(len(d.centroids), func(i int) bool { return d.centroids[i].mean > x })
+	return float64(d.countBefore(idx)) / float64(d.countTotal)
+}
+
+// linearCDF computes the fraction of the values in d's centroids, which must
+// not be empty, which are less than or equal to x, under Linear
+// interpolation.
+func (d *TDigest) linearCDF(x float64) float64 {
+	n := len(d.centroids)
+	// find the first centroid which is above x
+	i := sort.Search(n, func(i int) bool { return d.centroids[i].mean > x })
+	switch {
+	case i == 0:
+		return 0
+	case i == n || d.countTotal == 1:
+		return 1
+	}
+	// The last copy of the mean of centroid i-1 is at position cum[i], and
+	// the first copy of the mean of centroid i is just after it. Find x's
+	// position between them, and convert it into a quantile.
+	var (
+		c0, c1   = d.centroids[i-1], d.centroids[i]
+		position = float64(d.countBefore(i)) + (x-c0.mean)/(c1.mean-c0.mean)
+	)
+	return math.Min(1, (position-1)/float64(d.countTotal-1))
+}
@@ -0,0 +1,90 @@
+package tdigest
+
+import (
+	"math"
+	"testing"
+)
+
+func TestInterpolation(t *testing.T) {
+	d := tdFromMeans([]float64{2, 3, 5, 7, 11, 13, 17, 19, 23, 29})
+	qs := []float64{0, 0.1, 0.25, 0.5, 0.9, 1}
+	// Reference values of R's quantile function with types 7 and 1, which
+	// are also those of SQL's percentile_cont and percentile_disc.
+	want := map[Interpolation][]float64{
+		Linear:      {2, 2.9, 5.5, 12, 23.6, 29},
+		NearestRank: {2, 2, 5, 11, 23, 29},
+	}
+	for interpolation, values := range want {
+		d.interpolation = interpolation
+		for i, q := range qs {
+			if have := d.Quantile(q); math.Abs(have-values[i]) > 1e-9 {
+				t.Errorf("interpolation %d: Quantile(%v) wrong, have=%v, want=%v", interpolation, q, have, values[i])
+			}
+		}
+	}
+}
+
+func TestWithInterpolation(t *testing.T) {
+	d := New(WithInterpolation(NearestRank))
+	for _, v := range []float64{1, 2, 3, 4} {
+		d.Add(v, 1)
+	}
+	have := d.Quantile(0.3)
+	for _, c := range d.Centroids() {
+		if have == c.Mean {
+			return
+		}
+	}
+	t.Errorf("NearestRank Quantile is not a centroid mean, have=%v, centroids=%v", have, d.Centroids())
+}
+
+func TestInterpolationWeights(t *testing.T) {
+	// Centroids holding the values 0, 0, 0, 1, 2, 2, 2, 2.
+	d := tdFromWeights([]int64{3, 1, 4})
+
+	type testcase struct {
+		interpolation Interpolation
+		q             float64
+		want          float64
+	}
+	testcases := []testcase{
+		{Linear, 0.25, 0},
+		{Linear, 0.5, 1.5},
+		{Linear, 0.5 + 0.5/7, 2},
+		{NearestRank, 0.375, 0},
+		{NearestRank, 0.376, 1},
+		{NearestRank, 0.5, 1},
+		{NearestRank, 0.51, 2},
+	}
+	for _, tc := range testcases {
+		d.interpolation = tc.interpolation
+		if have := d.Quantile(tc.q); have != tc.want {
+			t.Errorf("interpolation %d: Quantile(%v) wrong, have=%v, want=%v", tc.interpolation, tc.q, have, tc.want)
+		}
+	}
+}
+
+func TestInterpolationCDF(t *testing.T) {
+	d := tdFromMeans([]float64{2, 3, 5, 7, 11, 13, 17, 19, 23, 29})
+	d.interpolation = Linear
+
+	for q := 0.0; q <= 1; q += 0.01 {
+		if have := d.CDF(d.Quantile(q)); math.Abs(have-q) > 1e-9 {
+			t.Errorf("Linear CDF is not the inverse of Quantile at q=%v, have=%v", q, have)
+		}
+	}
+	if have := d.CDF(1); have != 0 {
+		t.Errorf("CDF below smallest value wrong, have=%v", have)
+	}
+	if have := d.CDF(30); have != 1 {
+		t.Errorf("CDF above largest value wrong, have=%v", have)
+	}
+
+	d.interpolation = NearestRank
+	for q := 0.01; q <= 1; q += 0.01 {
+		x := d.Quantile(q)
+		if have := d.CDF(x); have < q-1e-9 {
+			t.Errorf("NearestRank CDF(Quantile(%v)) is below %v, have=%v", q, q, have)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package tdigest
+
+import (
+	"container/list"
+	"sync"
+	"unsafe"
+)
+
+// KeyedOptions configures a Keyed.
+type KeyedOptions struct {
+	// MaxKeys is the most keys a Keyed holds TDigests for. When a value is
+	// added for a new key while it is full, the least recently updated key
+	// is evicted to make room. A limit of 0 means no limit.
+	MaxKeys int
+	// MergeEvicted makes the TDigests of evicted keys be merged into a
+	// single TDigest, returned by Other, rather than being discarded.
+	MergeEvicted bool
+}
+
+// A Keyed holds a TDigest for each key it has observed values for, like one
+// per customer ID, up to a limit. The TDigests are created on demand, all
+// with the same options. Keys which have not been updated recently are
+// evicted to stay within the limit:
+//
+//	latency := tdigest.NewKeyed[string](tdigest.KeyedOptions{
+//		MaxKeys:      1000,
+//		MergeEvicted: true,
+//	})
+//	latency.Add(customerID, elapsed.Seconds(), 1)
+//
+// A Keyed is safe for concurrent use.
+type Keyed[K comparable] struct {
+	opts         []Option
+	maxKeys      int
+	mergeEvicted bool
+
+	// mu guards all of the fields below, and the TDigests they hold.
+	mu      sync.Mutex
+	entries map[K]*list.Element
+	// recent holds a *keyedEntry[K] for each key, with the most recently
+	// updated at the front.
+	recent    *list.List
+	other     *TDigest
+	evictions int64
+}
+
+type keyedEntry[K comparable] struct {
+	key K
+	d   *TDigest
+}
+
+// NewKeyed produces a new, empty Keyed configured by ko, which creates
+// TDigests with the provided options.
+func NewKeyed[K comparable](ko KeyedOptions, opts ...Option) *Keyed[K] {
+	k := &Keyed[K]{
+		opts:         opts,
+		maxKeys:      ko.MaxKeys,
+		mergeEvicted: ko.MergeEvicted,
+		entries:      make(map[K]*list.Element),
+		recent:       list.New(),
+	}
+	if k.mergeEvicted {
+		k.other = New(opts...)
+	}
+	return k
+}
+
+// Add adds a value to the TDigest for key, creating it if necessary, and
+// marks key as the most recently updated. See TDigest.Add.
+func (k *Keyed[K]) Add(key K, val float64, weight int) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.get(key).Add(val, weight)
+}
+
+// MergeFrom merges all of the data in d into the TDigest for key, creating it
+// if necessary, and marks key as the most recently updated. d is unchanged.
+func (k *Keyed[K]) MergeFrom(key K, d *TDigest) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	d.MergeInto(k.get(key))
+}
+
+// get returns the TDigest for key, creating it if necessary, and marks key as
+// the most recently updated. k.mu must be held.
+func (k *Keyed[K]) get(key K) *TDigest {
+	if el, ok := k.entries[key]; ok {
+		k.recent.MoveToFront(el)
+		return el.Value.(*keyedEntry[K]).d
+	}
+	if k.maxKeys > 0 && len(k.entries) >= k.maxKeys {
+		k.evictOldest()
+	}
+	e := &keyedEntry[K]{key: key, d: New(k.opts...)}
+	k.entries[key] = k.recent.PushFront(e)
+	return e.d
+}
+
+// evictOldest removes the least recently updated key. k.mu must be held, and
+// k must not be empty.
+func (k *Keyed[K]) evictOldest() {
+	el := k.recent.Back()
+	e := k.recent.Remove(el).(*keyedEntry[K])
+	delete(k.entries, e.key)
+	k.evictions++
+	if k.mergeEvicted {
+		e.d.MergeInto(k.other)
+	}
+}
+
+// Get returns a copy of the TDigest for key, or nil if k holds none. It does
+// not count as an update of key.
+func (k *Keyed[K]) Get(key K) *TDigest {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	el, ok := k.entries[key]
+	if !ok {
+		return nil
+	}
+	return el.Value.(*keyedEntry[K]).d.Clone()
+}
+
+// Remove discards the TDigest for key, if there is one, without merging it
+// into Other. It reports whether there was one.
+func (k *Keyed[K]) Remove(key K) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	el, ok := k.entries[key]
+	if !ok {
+		return false
+	}
+	k.recent.Remove(el)
+	delete(k.entries, key)
+	return true
+}
+
+// Other returns a copy of the TDigest holding the data of all evicted keys,
+// or nil if k was not configured with MergeEvicted.
+func (k *Keyed[K]) Other() *TDigest {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.other == nil {
+		return nil
+	}
+	return k.other.Clone()
+}
+
+// Each calls fn with every key in k and its TDigest, from the most to the
+// least recently updated. k is locked for the duration of the calls, so fn
+// must not use k or keep a reference to the TDigest.
+func (k *Keyed[K]) Each(fn func(key K, d *TDigest)) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for el := k.recent.Front(); el != nil; el = el.Next() {
+		e := el.Value.(*keyedEntry[K])
+		fn(e.key, e.d)
+	}
+}
+
+// Len returns the number of keys in k.
+func (k *Keyed[K]) Len() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return len(k.entries)
+}
+
+// Evictions returns the number of keys which have been evicted from k to stay
+// within its limit.
+func (k *Keyed[K]) Evictions() int64 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.evictions
+}
+
+// SizeBytes estimates the number of bytes of memory used by k, from the
+// number of centroids held by each of its TDigests and a fixed overhead per
+// key. It does not include memory referenced by the keys, like the contents
+// of strings.
+func (k *Keyed[K]) SizeBytes() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	var (
+		header = unsafe.Sizeof(*k) + unsafe.Sizeof(*k.recent)
+		perKey = unsafe.Sizeof(keyedEntry[K]{}) + unsafe.Sizeof(list.Element{}) +
+			// a map entry holds the key and a pointer to the list element
+			unsafe.Sizeof(keyedEntry[K]{}.key) + unsafe.Sizeof(uintptr(0))
+	)
+	size := int(header) + len(k.entries)*int(perKey)
+	for el := k.recent.Front(); el != nil; el = el.Next() {
+		size += el.Value.(*keyedEntry[K]).d.SizeBytes()
+	}
+	if k.other != nil {
+		size += k.other.SizeBytes()
+	}
+	return size
+}
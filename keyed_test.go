@@ -0,0 +1,125 @@
+package tdigest
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestKeyedEviction(t *testing.T) {
+	k := NewKeyed[string](KeyedOptions{MaxKeys: 2, MergeEvicted: true}, WithCompression(50))
+	k.Add("a", 1, 1)
+	k.Add("b", 2, 1)
+	k.Add("a", 3, 1) // a is now more recent than b
+	k.Add("c", 4, 1) // evicts b
+
+	if have := k.Len(); have != 2 {
+		t.Errorf("Keyed.Len wrong, have=%d, want=%d", have, 2)
+	}
+	if k.Get("b") != nil {
+		t.Error("least recently updated key was not evicted")
+	}
+	if have := k.Get("a"); have == nil || have.Count() != 2 || have.Compression() != 50 {
+		t.Errorf("Keyed.Get returned wrong TDigest for a: %v", have)
+	}
+	other := k.Other()
+	if other.Count() != 1 || other.Min() != 2 || other.Compression() != 50 {
+		t.Errorf("evicted key not merged into other, count=%d min=%v", other.Count(), other.Min())
+	}
+	if have := k.Evictions(); have != 1 {
+		t.Errorf("Keyed.Evictions wrong, have=%d, want=%d", have, 1)
+	}
+
+	var keys []string
+	k.Each(func(key string, d *TDigest) {
+		keys = append(keys, key)
+	})
+	if want := []string{"c", "a"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("Keyed.Each wrong order, have=%v, want=%v", keys, want)
+	}
+
+	// Reading a key does not make it recent, and removing one does not
+	// count as an eviction.
+	k.Get("a")
+	k.MergeFrom("d", tdFromMeans([]float64{5, 6}))
+	if k.Get("a") != nil || k.Get("d").Count() != 2 {
+		t.Errorf("MergeFrom did not evict a, have keys a=%v d=%v", k.Get("a"), k.Get("d"))
+	}
+	if !k.Remove("c") || k.Remove("c") {
+		t.Error("Keyed.Remove wrong")
+	}
+	if k.Len() != 1 || k.Evictions() != 2 || k.Other().Count() != 3 {
+		t.Errorf("Keyed has wrong state, len=%d evictions=%d other=%d", k.Len(), k.Evictions(), k.Other().Count())
+	}
+}
+
+func TestKeyedNoOther(t *testing.T) {
+	k := NewKeyed[int](KeyedOptions{MaxKeys: 1})
+	k.Add(1, 1, 1)
+	k.Add(2, 2, 1)
+	if k.Other() != nil {
+		t.Error("Keyed.Other should be nil without MergeEvicted")
+	}
+	if k.Len() != 1 || k.Get(2) == nil {
+		t.Errorf("Keyed has wrong keys, len=%d", k.Len())
+	}
+
+	unlimited := NewKeyed[int](KeyedOptions{})
+	for i := 0; i < 100; i++ {
+		unlimited.Add(i, 1, 1)
+	}
+	if unlimited.Len() != 100 || unlimited.Evictions() != 0 {
+		t.Errorf("Keyed without limit evicted keys, len=%d", unlimited.Len())
+	}
+}
+
+func TestKeyedSizeBytes(t *testing.T) {
+	k := NewKeyed[int](KeyedOptions{MaxKeys: 10})
+	empty := k.SizeBytes()
+	k.Add(1, 1, 1)
+	one := k.SizeBytes()
+	if one <= empty {
+		t.Errorf("Keyed.SizeBytes should grow with keys, have %d empty and %d with one key", empty, one)
+	}
+	for i := 0; i < 1000; i++ {
+		k.Add(1, float64(i), 1)
+	}
+	if have := k.SizeBytes(); have <= one {
+		t.Errorf("Keyed.SizeBytes should grow with centroids, have %d, was %d", have, one)
+	}
+
+	// The limit bounds the memory used.
+	for i := 0; i < 100; i++ {
+		k.Add(i, 1, 1)
+	}
+	full := k.SizeBytes()
+	for i := 100; i < 200; i++ {
+		k.Add(i, 1, 1)
+	}
+	if have := k.SizeBytes(); have != full {
+		t.Errorf("Keyed.SizeBytes grew past the limit, have %d, want %d", have, full)
+	}
+}
+
+func TestKeyedConcurrent(t *testing.T) {
+	k := NewKeyed[int](KeyedOptions{MaxKeys: 10, MergeEvicted: true})
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				k.Add(w*1000+i%50, float64(i), 1)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	total := k.Other().Count()
+	k.Each(func(key int, d *TDigest) {
+		total += d.Count()
+	})
+	if total != 4000 {
+		t.Errorf("Keyed lost values, have=%d, want=%d", total, 4000)
+	}
+}
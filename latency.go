@@ -0,0 +1,157 @@
+package tdigest
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/spenczar/tdigest/v2/internal/percentile"
+)
+
+// A LatencyDigest is a TDigest of durations, like request latencies. It
+// stores each duration as a number of some unit, like milliseconds, so its
+// TDigest and serialized form hold values in that unit:
+//
+//	latency := tdigest.NewLatencyDigest(time.Millisecond)
+//	start := time.Now()
+//	...
+//	latency.ObserveSince(start)
+//	...
+//	log.Printf("latency: %s", latency.Summary(0.5, 0.99)) // p50=12ms p99=340ms
+//
+// Like a TDigest, a LatencyDigest is not safe for concurrent use.
+type LatencyDigest struct {
+	td   *TDigest
+	unit time.Duration
+}
+
+// NewLatencyDigest produces a new, empty LatencyDigest which stores durations
+// as a number of unit, configured with the provided options. It panics if unit
+// is not positive.
+func NewLatencyDigest(unit time.Duration, opts ...Option) *LatencyDigest {
+	if unit <= 0 {
+		panic("tdigest: non-positive unit for NewLatencyDigest")
+	}
+	return &LatencyDigest{td: New(opts...), unit: unit}
+}
+
+// Unit returns the unit l stores durations in.
+func (l *LatencyDigest) Unit() time.Duration {
+	return l.unit
+}
+
+// Observe adds a duration to l.
+func (l *LatencyDigest) Observe(d time.Duration) {
+	l.td.Add(l.toUnit(d), 1)
+}
+
+// ObserveSince adds the time elapsed since start to l.
+func (l *LatencyDigest) ObserveSince(start time.Time) {
+	l.Observe(time.Since(start))
+}
+
+// Quantile estimates the qth quantile of l's durations. See TDigest.Quantile.
+// Calling Quantile on a LatencyDigest with no data will return 0.
+func (l *LatencyDigest) Quantile(q float64) time.Duration {
+	return l.fromUnit(l.td.Quantile(q))
+}
+
+// CDF estimates the fraction of l's durations which are less than or equal to
+// d. See TDigest.CDF.
+func (l *LatencyDigest) CDF(d time.Duration) float64 {
+	return l.td.CDF(l.toUnit(d))
+}
+
+// Count returns the number of durations added to l.
+func (l *LatencyDigest) Count() int64 {
+	return l.td.Count()
+}
+
+// Min returns the shortest duration added to l, or 0 if l is empty.
+func (l *LatencyDigest) Min() time.Duration {
+	return l.fromUnit(l.td.Min())
+}
+
+// Max returns the longest duration added to l, or 0 if l is empty.
+func (l *LatencyDigest) Max() time.Duration {
+	return l.fromUnit(l.td.Max())
+}
+
+// MergeInto merges all of l's data into other, converting it into other's
+// unit if they differ. See TDigest.MergeInto.
+func (l *LatencyDigest) MergeInto(other *LatencyDigest) {
+	if l.unit == other.unit {
+		l.td.MergeInto(other.td)
+		return
+	}
+	scaled := l.td.Clone()
+	scaled.scale(float64(l.unit) / float64(other.unit))
+	scaled.MergeInto(other.td)
+}
+
+// Reset removes all data from l, keeping its configuration.
+func (l *LatencyDigest) Reset() {
+	l.td.Reset()
+}
+
+// TDigest returns the TDigest that holds l's data, as numbers of l's unit.
+// Changes to it are reflected in l.
+func (l *LatencyDigest) TDigest() *TDigest {
+	return l.td
+}
+
+// Summary returns a short, human-readable summary of the given quantiles of
+// l, named as percentiles, like "p50=12ms p99=340ms". Durations are rounded to
+// three significant figures. An empty LatencyDigest is summarized as
+// "count=0".
+func (l *LatencyDigest) Summary(quantiles ...float64) string {
+	if l.Count() == 0 {
+		return "count=0"
+	}
+	var b strings.Builder
+	for i, q := range quantiles {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(percentile.Name(q))
+		b.WriteByte('=')
+		b.WriteString(roundDuration(l.Quantile(q)).String())
+	}
+	return b.String()
+}
+
+// String returns a summary of the median, 90th and 99th percentiles of l. See
+// Summary.
+func (l *LatencyDigest) String() string {
+	return l.Summary(0.5, 0.9, 0.99)
+}
+
+func (l *LatencyDigest) toUnit(d time.Duration) float64 {
+	return float64(d) / float64(l.unit)
+}
+
+func (l *LatencyDigest) fromUnit(v float64) time.Duration {
+	if math.IsNaN(v) {
+		return 0
+	}
+	return time.Duration(math.Round(v * float64(l.unit)))
+}
+
+// scale multiplies all of the values in d by factor, which must be positive.
+func (d *TDigest) scale(factor float64) {
+	for i := range d.centroids {
+		d.centroids[i].mean *= factor
+	}
+	d.min *= factor
+	d.max *= factor
+}
+
+// roundDuration rounds d to three significant figures, so that it prints
+// compactly, like 12.3ms rather than 12.345678ms.
+func roundDuration(d time.Duration) time.Duration {
+	m := time.Duration(1)
+	for d/m >= 1000 || d/m <= -1000 {
+		m *= 10
+	}
+	return d.Round(m)
+}
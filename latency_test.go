@@ -0,0 +1,74 @@
+package tdigest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyDigest(t *testing.T) {
+	l := NewLatencyDigest(time.Millisecond)
+	if have := l.Summary(0.5); have != "count=0" {
+		t.Errorf("LatencyDigest.Summary of empty digest wrong, have=%q", have)
+	}
+	for i := 1; i <= 100; i++ {
+		l.Observe(time.Duration(i) * time.Millisecond)
+	}
+	if l.Count() != 100 || l.Min() != time.Millisecond || l.Max() != 100*time.Millisecond {
+		t.Errorf("LatencyDigest has wrong state, count=%d min=%v max=%v", l.Count(), l.Min(), l.Max())
+	}
+	// values are stored in milliseconds
+	if have := l.TDigest().Max(); have != 100 {
+		t.Errorf("LatencyDigest stored wrong value, have=%v, want=%v", have, 100)
+	}
+	if have := l.Quantile(1); have != 100*time.Millisecond {
+		t.Errorf("LatencyDigest.Quantile(1) wrong, have=%v", have)
+	}
+	if have := l.CDF(100 * time.Millisecond); have <= 0.99 || have > 1 {
+		t.Errorf("LatencyDigest.CDF wrong, have=%v", have)
+	}
+	if have, want := l.Summary(0, 1), "p0=1ms p100=100ms"; have != want {
+		t.Errorf("LatencyDigest.Summary wrong, have=%q, want=%q", have, want)
+	}
+
+	start := time.Now().Add(-time.Second)
+	l.Reset()
+	l.ObserveSince(start)
+	if have := l.Min(); have < time.Second {
+		t.Errorf("LatencyDigest.ObserveSince recorded too short a duration, have=%v", have)
+	}
+}
+
+func TestLatencyDigestMergeUnits(t *testing.T) {
+	ms := NewLatencyDigest(time.Millisecond)
+	ms.Observe(1500 * time.Millisecond)
+	s := NewLatencyDigest(time.Second)
+	s.Observe(3 * time.Second)
+
+	ms.MergeInto(s)
+	if s.Count() != 2 || s.Min() != 1500*time.Millisecond || s.Max() != 3*time.Second {
+		t.Errorf("merge across units wrong, count=%d min=%v max=%v", s.Count(), s.Min(), s.Max())
+	}
+	if have := ms.TDigest().Min(); have != 1500 {
+		t.Errorf("merge changed source digest, min=%v", have)
+	}
+}
+
+func TestRoundDuration(t *testing.T) {
+	type testcase struct {
+		in   time.Duration
+		want string
+	}
+	testcases := []testcase{
+		{0, "0s"},
+		{999 * time.Nanosecond, "999ns"},
+		{12345678 * time.Nanosecond, "12.3ms"},
+		{340 * time.Millisecond, "340ms"},
+		{-1234 * time.Microsecond, "-1.23ms"},
+		{90 * time.Minute, "1h30m0s"},
+	}
+	for i, tc := range testcases {
+		if have := roundDuration(tc.in).String(); have != tc.want {
+			t.Errorf("roundDuration wrong test=%d, have=%q, want=%q", i, have, tc.want)
+		}
+	}
+}
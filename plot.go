@@ -0,0 +1,238 @@
+package tdigest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// PlotOptions configures the plots drawn by WriteHistogram and WriteCDF.
+type PlotOptions struct {
+	// Width is the number of columns in the plot area, not counting the
+	// axis labels. It defaults to 60.
+	Width int
+	// Height is the number of rows in the plot area. It defaults to 12.
+	Height int
+	// LogScale makes the x axis logarithmic. It can only be used when all
+	// of the data is greater than zero.
+	LogScale bool
+	// ASCII restricts the plot to ASCII characters. By default, Unicode
+	// block elements are used to draw bars with a finer resolution.
+	ASCII bool
+}
+
+func (o PlotOptions) withDefaults() PlotOptions {
+	if o.Width <= 0 {
+		o.Width = 60
+	}
+	if o.Height <= 0 {
+		o.Height = 12
+	}
+	return o
+}
+
+// WriteHistogram draws a histogram of the data in d to w, as text. Each column
+// of the plot covers an equal slice of the x axis between the lowest and
+// highest centroids, and its height is proportional to the weight of data in
+// that slice.
+//
+// The weight of each centroid is assumed to be spread evenly from halfway to
+// its left neighbor to halfway to its right neighbor.
+func (d *TDigest) WriteHistogram(w io.Writer, opts PlotOptions) error {
+	opts = opts.withDefaults()
+	axis, err := d.plotAxis(opts)
+	if err != nil {
+		return err
+	}
+
+	bins := make([]float64, opts.Width)
+	n := len(d.centroids)
+	for i, c := range d.centroids {
+		lo, hi := axis.scale(c.mean), axis.scale(c.mean)
+		if i > 0 {
+			lo = (axis.scale(d.centroids[i-1].mean) + lo) / 2
+		}
+		if i < n-1 {
+			hi = (axis.scale(d.centroids[i+1].mean) + hi) / 2
+		}
+		axis.spread(bins, lo, hi, float64(c.count))
+	}
+
+	var tallest float64
+	for _, b := range bins {
+		if b > tallest {
+			tallest = b
+		}
+	}
+	return writeBars(w, bins, tallest, fmt.Sprintf("%.4g", tallest), "0", axis, opts)
+}
+
+// WriteCDF draws the cumulative distribution function of the data in d to w,
+// as text. Each column of the plot covers an equal slice of the x axis
+// between the lowest and highest centroids, and its height is the fraction of
+// the data at or below the middle of that slice.
+func (d *TDigest) WriteCDF(w io.Writer, opts PlotOptions) error {
+	opts = opts.withDefaults()
+	axis, err := d.plotAxis(opts)
+	if err != nil {
+		return err
+	}
+
+	cols := make([]float64, opts.Width)
+	for i := range cols {
+		cols[i] = d.CDF(axis.unscale(axis.lo + (float64(i)+0.5)*axis.binWidth()))
+	}
+	return writeBars(w, cols, 1, "1", "0", axis, opts)
+}
+
+// plotAxis describes the x axis of a plot. lo and hi are in scaled units:
+// logarithms of the data when plotting on a log scale.
+type plotAxis struct {
+	lo, hi float64
+	width  int
+	log    bool
+}
+
+func (d *TDigest) plotAxis(opts PlotOptions) (plotAxis, error) {
+	if len(d.centroids) == 0 {
+		return plotAxis{}, errors.New("cannot plot a TDigest with no data")
+	}
+	lo, hi := d.centroids[0].mean, d.centroids[len(d.centroids)-1].mean
+	if opts.LogScale && lo <= 0 {
+		return plotAxis{}, fmt.Errorf("cannot plot data including %v on a log scale", lo)
+	}
+	a := plotAxis{width: opts.Width, log: opts.LogScale}
+	a.lo, a.hi = a.scale(lo), a.scale(hi)
+	return a, nil
+}
+
+func (a plotAxis) scale(x float64) float64 {
+	if a.log {
+		return math.Log10(x)
+	}
+	return x
+}
+
+func (a plotAxis) unscale(x float64) float64 {
+	if a.log {
+		return math.Pow(10, x)
+	}
+	return x
+}
+
+func (a plotAxis) binWidth() float64 {
+	return (a.hi - a.lo) / float64(a.width)
+}
+
+// bin returns the index of the column holding the scaled value x.
+func (a plotAxis) bin(x float64) int {
+	if a.hi == a.lo {
+		return a.width / 2
+	}
+	i := int((x - a.lo) / a.binWidth())
+	if i < 0 {
+		return 0
+	}
+	if i >= a.width {
+		return a.width - 1
+	}
+	return i
+}
+
+// spread adds weight to bins, dividing it evenly across the scaled range
+// [lo, hi].
+func (a plotAxis) spread(bins []float64, lo, hi, weight float64) {
+	first, last := a.bin(lo), a.bin(hi)
+	if first == last || hi <= lo {
+		bins[first] += weight
+		return
+	}
+	for i := first; i <= last; i++ {
+		binLo := a.lo + float64(i)*a.binWidth()
+		binHi := binLo + a.binWidth()
+		overlap := math.Min(hi, binHi) - math.Max(lo, binLo)
+		if overlap > 0 {
+			bins[i] += weight * overlap / (hi - lo)
+		}
+	}
+}
+
+var blockElements = []rune{' ', '▁', '▂', '▃', '▄', '▅', '▆', '▇', '█'}
+
+// writeBars draws a bar chart of values, scaled so that a value of top fills
+// the plot area, with y axis labels at the top and bottom, and x axis labels
+// from axis.
+func writeBars(w io.Writer, values []float64, top float64, topLabel, bottomLabel string, axis plotAxis, opts PlotOptions) error {
+	var (
+		bw         = bufio.NewWriter(w)
+		labelWidth = len(topLabel)
+		// eighths is the number of eighths of a row each bar fills.
+		eighths = make([]int, len(values))
+
+		tick, vline, hline, corner, full = "┤", "│", "─", "└", '█'
+	)
+	if opts.ASCII {
+		tick, vline, hline, corner, full = "+", "|", "-", "+", '#'
+	}
+	if len(bottomLabel) > labelWidth {
+		labelWidth = len(bottomLabel)
+	}
+	for i, v := range values {
+		if top > 0 {
+			eighths[i] = int(math.Round(v / top * float64(opts.Height*8)))
+		}
+		if opts.ASCII {
+			// round to whole rows
+			eighths[i] = (eighths[i] + 4) / 8 * 8
+		}
+	}
+
+	row := make([]rune, len(values))
+	for r := opts.Height - 1; r >= 0; r-- {
+		label, axisChar := "", vline
+		switch r {
+		case opts.Height - 1:
+			label, axisChar = topLabel, tick
+		case 0:
+			label, axisChar = bottomLabel, tick
+		}
+		for i, e := range eighths {
+			switch fill := e - r*8; {
+			case fill >= 8:
+				row[i] = full
+			case fill <= 0:
+				row[i] = ' '
+			default:
+				row[i] = blockElements[fill]
+			}
+		}
+		fmt.Fprintf(bw, "%*s %s%s\n", labelWidth, label, axisChar, strings.TrimRight(string(row), " "))
+	}
+
+	fmt.Fprintf(bw, "%*s %s%s\n", labelWidth, "", corner, strings.Repeat(hline, len(values)))
+	fmt.Fprintf(bw, "%*s  %s\n", labelWidth, "", axis.labels())
+	return bw.Flush()
+}
+
+// labels returns a line of labels for the left, middle and right of the axis.
+func (a plotAxis) labels() string {
+	var (
+		left  = fmt.Sprintf("%.4g", a.unscale(a.lo))
+		mid   = fmt.Sprintf("%.4g", a.unscale((a.lo+a.hi)/2))
+		right = fmt.Sprintf("%.4g", a.unscale(a.hi))
+		line  = []rune(strings.Repeat(" ", a.width))
+	)
+	// Place the middle label only if it fits between the other two.
+	midStart := a.width/2 - len(mid)/2
+	if midStart > len(left) && midStart+len(mid) < a.width-len(right) {
+		copy(line[midStart:], []rune(mid))
+	}
+	copy(line, []rune(left))
+	if len(right) <= a.width-len(left) {
+		copy(line[a.width-len(right):], []rune(right))
+	}
+	return strings.TrimRight(string(line), " ")
+}
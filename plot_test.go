@@ -0,0 +1,72 @@
+package tdigest
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteHistogram(t *testing.T) {
+	d := tdFromWeights([]int64{1, 2, 4, 1})
+	var buf bytes.Buffer
+	if err := d.WriteHistogram(&buf, PlotOptions{Width: 8, Height: 4, ASCII: true}); err != nil {
+		t.Fatalf("WriteHistogram err: %v", err)
+	}
+	want := strings.Join([]string{
+		"1.5 +    ##",
+		"    |    ###",
+		"    |########",
+		"  0 +########",
+		"    +--------",
+		"     0  1.5 3",
+		"",
+	}, "\n")
+	if have := buf.String(); have != want {
+		t.Errorf("WriteHistogram wrong, have:\n%s\nwant:\n%s", have, want)
+	}
+}
+
+func TestWriteCDF(t *testing.T) {
+	d := tdFromWeights([]int64{1, 1, 1, 1})
+	var buf bytes.Buffer
+	if err := d.WriteCDF(&buf, PlotOptions{Width: 6, Height: 2}); err != nil {
+		t.Fatalf("WriteCDF err: %v", err)
+	}
+	want := strings.Join([]string{
+		"1 ┤   ▁▃▅",
+		"0 ┤▃▅▇███",
+		"  └──────",
+		"   0    3",
+		"",
+	}, "\n")
+	if have := buf.String(); have != want {
+		t.Errorf("WriteCDF wrong, have:\n%s\nwant:\n%s", have, want)
+	}
+}
+
+func TestPlotErrors(t *testing.T) {
+	var buf bytes.Buffer
+	if err := New().WriteHistogram(&buf, PlotOptions{}); err == nil {
+		t.Error("expected error plotting an empty TDigest, got nil")
+	}
+	if err := tdFromMeans([]float64{0, 1}).WriteCDF(&buf, PlotOptions{LogScale: true}); err == nil {
+		t.Error("expected error plotting zero on a log scale, got nil")
+	}
+}
+
+func TestPlotLogScale(t *testing.T) {
+	d := tdFromMeans([]float64{1, 10, 100, 1000})
+	var buf bytes.Buffer
+	if err := d.WriteHistogram(&buf, PlotOptions{Width: 30, Height: 3, LogScale: true}); err != nil {
+		t.Fatalf("WriteHistogram err: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("wrong number of lines, have=%d, want=%d:\n%s", len(lines), 5, buf.String())
+	}
+	// The centroids are evenly spaced on a log scale, so the middle label
+	// is their geometric mean.
+	if labels := lines[len(lines)-1]; !strings.Contains(labels, "31.62") {
+		t.Errorf("log scale axis labels wrong: %q", labels)
+	}
+}
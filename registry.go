@@ -0,0 +1,322 @@
+package tdigest
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Labels identify one of the TDigests held in a Registry, like
+// {"endpoint": "/api/users", "status": "200"}.
+type Labels map[string]string
+
+// key returns a canonical string for l, which is the same for all Labels
+// holding the same pairs.
+func (l Labels) key() string {
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = strconv.Quote(name) + "=" + strconv.Quote(l[name])
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l Labels) clone() Labels {
+	c := make(Labels, len(l))
+	for name, v := range l {
+		c[name] = v
+	}
+	return c
+}
+
+// A Registry holds a TDigest for each distinct set of Labels it has observed
+// values for. The TDigests are created on demand, all with the same options.
+// A Registry is safe for concurrent use.
+type Registry struct {
+	opts []Option
+
+	// mu guards entries. It is held for reading while any entry is being
+	// updated, so that holding it for writing ensures no updates are in
+	// progress.
+	mu      sync.RWMutex
+	entries map[string]*registryEntry
+}
+
+type registryEntry struct {
+	labels Labels
+
+	mu sync.Mutex
+	d  *TDigest
+}
+
+// NewRegistry produces a new, empty Registry which creates TDigests with the
+// provided options.
+func NewRegistry(opts ...Option) *Registry {
+	return &Registry{
+		opts:    opts,
+		entries: make(map[string]*registryEntry),
+	}
+}
+
+// Observe adds val, with a weight of 1, to the TDigest for labels.
+func (r *Registry) Observe(labels Labels, val float64) {
+	r.update(labels, func(d *TDigest) {
+		d.Add(val, 1)
+	})
+}
+
+// update calls fn with the TDigest for labels, creating it if necessary. The
+// TDigest is locked for the duration of the call.
+func (r *Registry) update(labels Labels, fn func(d *TDigest)) {
+	key := labels.key()
+
+	r.mu.RLock()
+	e := r.entries[key]
+	for e == nil {
+		// Upgrade to a write lock to create the entry. It may be
+		// removed by a Snapshot before the read lock is reacquired, so
+		// check again.
+		r.mu.RUnlock()
+		r.mu.Lock()
+		if r.entries[key] == nil {
+			r.entries[key] = r.newEntry(labels)
+		}
+		r.mu.Unlock()
+		r.mu.RLock()
+		e = r.entries[key]
+	}
+
+	e.mu.Lock()
+	fn(e.d)
+	e.mu.Unlock()
+	r.mu.RUnlock()
+}
+
+func (r *Registry) newEntry(labels Labels) *registryEntry {
+	return &registryEntry{labels: labels.clone(), d: New(r.opts...)}
+}
+
+// Len returns the number of distinct sets of labels in r.
+func (r *Registry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.entries)
+}
+
+// Snapshot returns all of the data observed by r in a new Registry, and resets
+// r to be empty. No observations are lost or counted twice, even when Observe
+// is called concurrently.
+func (r *Registry) Snapshot() *Registry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	snap := &Registry{
+		opts:    r.opts,
+		entries: r.entries,
+	}
+	r.entries = make(map[string]*registryEntry)
+	return snap
+}
+
+// Each calls fn with the labels and TDigest of every series in r, in a
+// consistent order. The TDigest is locked against concurrent updates for the
+// duration of each call, so fn must not keep a reference to it, and neither
+// fn nor Observe should modify the labels.
+func (r *Registry) Each(fn func(labels Labels, d *TDigest)) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, key := range r.sortedKeys() {
+		e := r.entries[key]
+		e.mu.Lock()
+		fn(e.labels, e.d)
+		e.mu.Unlock()
+	}
+}
+
+// sortedKeys returns the keys of r.entries in order. r.mu must be held.
+func (r *Registry) sortedKeys() []string {
+	keys := make([]string, 0, len(r.entries))
+	for key := range r.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// MergeInto adds all of the data in r into other, merging each TDigest into
+// the one with the same labels in other.
+func (r *Registry) MergeInto(other *Registry) {
+	// Copy the TDigests in r first, so that r and other are never locked at
+	// the same time.
+	type series struct {
+		labels Labels
+		d      *TDigest
+	}
+	var copies []series
+	r.Each(func(labels Labels, d *TDigest) {
+		copies = append(copies, series{labels.clone(), d.Clone()})
+	})
+
+	for _, s := range copies {
+		other.update(s.labels, s.d.MergeInto)
+	}
+}
+
+const (
+	registryMagic           = int16(0xc81)
+	registryEncodingVersion = int32(1)
+)
+
+// MarshalBinary serializes all of the TDigests in r, along with their labels,
+// so that they can be merged into another Registry with MergeBinary.
+func (r *Registry) MarshalBinary() ([]byte, error) {
+	// Hold r.mu throughout, so that no series can be added between writing
+	// the number of them and writing each one.
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	keys := r.sortedKeys()
+
+	buf := bytes.NewBuffer(nil)
+	w := &binaryBufferWriter{buf: buf}
+	w.writeValue(registryMagic)
+	w.writeValue(registryEncodingVersion)
+	w.writeValue(int32(len(keys)))
+
+	for _, key := range keys {
+		e := r.entries[key]
+		w.writeValue(int32(len(e.labels)))
+		names := make([]string, 0, len(e.labels))
+		for name := range e.labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			w.writeString(name)
+			w.writeString(e.labels[name])
+		}
+		e.mu.Lock()
+		p, err := e.d.MarshalBinary()
+		e.mu.Unlock()
+		if err != nil && w.err == nil {
+			w.err = err
+		}
+		w.writeValue(int32(len(p)))
+		w.writeValue(p)
+	}
+
+	if w.err != nil {
+		return nil, w.err
+	}
+	return buf.Bytes(), nil
+}
+
+// MergeBinary merges the TDigests serialized in p, which should have been
+// created with a call to MarshalBinary, into r. If p is invalid, r is left
+// unchanged.
+func (r *Registry) MergeBinary(p []byte) error {
+	var (
+		mv int16
+		ev int32
+		n  int32
+	)
+	br := &binaryReader{r: bytes.NewReader(p)}
+	br.readValue(&mv)
+	if br.err != nil {
+		return br.err
+	}
+	if mv != registryMagic {
+		return fmt.Errorf("data corruption detected: invalid registry header magic value 0x%04x", mv)
+	}
+	br.readValue(&ev)
+	if br.err != nil {
+		return br.err
+	}
+	if ev != registryEncodingVersion {
+		return fmt.Errorf("data corruption detected: invalid registry encoding version %d", ev)
+	}
+	br.readValue(&n)
+	if br.err != nil {
+		return br.err
+	}
+	if n < 0 {
+		return fmt.Errorf("data corruption detected: number of series cannot be negative, have %v", n)
+	}
+	if int(n) > br.r.Len() {
+		return fmt.Errorf("data corruption detected: invalid number of series %d", n)
+	}
+
+	// Decode everything before merging anything, so that errors leave r
+	// unchanged.
+	type series struct {
+		labels Labels
+		d      *TDigest
+	}
+	decoded := make([]series, 0, n)
+	for i := 0; i < int(n); i++ {
+		var nLabels, size int32
+		br.readValue(&nLabels)
+		if br.err != nil {
+			return br.err
+		}
+		if nLabels < 0 || int(nLabels) > br.r.Len() {
+			return fmt.Errorf("data corruption detected: invalid number of labels %d", nLabels)
+		}
+		labels := make(Labels, nLabels)
+		for j := 0; j < int(nLabels); j++ {
+			name := br.readString()
+			labels[name] = br.readString()
+		}
+		br.readValue(&size)
+		if br.err != nil {
+			return br.err
+		}
+		if size < 0 || int(size) > br.r.Len() {
+			return fmt.Errorf("data corruption detected: invalid tdigest size %d", size)
+		}
+		encoded := make([]byte, size)
+		br.readValue(encoded)
+		if br.err != nil {
+			return br.err
+		}
+
+		d := New(r.opts...)
+		if err := d.UnmarshalBinary(encoded); err != nil {
+			return fmt.Errorf("series %d: %v", i, err)
+		}
+		decoded = append(decoded, series{labels, d})
+	}
+	if n := br.r.Len(); n > 0 {
+		return fmt.Errorf("found %d unexpected bytes trailing the registry", n)
+	}
+
+	for _, s := range decoded {
+		r.update(s.labels, s.d.MergeInto)
+	}
+	return nil
+}
+
+func (w *binaryBufferWriter) writeString(s string) {
+	w.writeValue(int32(len(s)))
+	w.writeValue([]byte(s))
+}
+
+func (r *binaryReader) readString() string {
+	var n int32
+	r.readValue(&n)
+	if r.err != nil {
+		return ""
+	}
+	if n < 0 || int(n) > r.r.Len() {
+		r.err = fmt.Errorf("data corruption detected: invalid string length %d", n)
+		return ""
+	}
+	p := make([]byte, n)
+	r.readValue(p)
+	return string(p)
+}
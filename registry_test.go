@@ -0,0 +1,199 @@
+package tdigest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestLabelsKey(t *testing.T) {
+	a := Labels{"endpoint": "/users", "status": "200"}
+	b := Labels{"status": "200", "endpoint": "/users"}
+	if a.key() != b.key() {
+		t.Errorf("equal labels have different keys: %q, %q", a.key(), b.key())
+	}
+	// Separators within names and values must not cause collisions.
+	c := Labels{"a": `1","b"="2`}
+	d := Labels{"a": "1", "b": "2"}
+	if c.key() == d.key() {
+		t.Errorf("different labels have the same key %q", c.key())
+	}
+}
+
+func TestRegistryObserve(t *testing.T) {
+	r := NewRegistry(WithCompression(50))
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			labels := Labels{"status": "200"}
+			if w%2 == 1 {
+				labels = Labels{"status": "500"}
+			}
+			for i := 0; i < 1000; i++ {
+				r.Observe(labels, float64(i))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if r.Len() != 2 {
+		t.Fatalf("Registry has wrong number of series, have=%d, want=%d", r.Len(), 2)
+	}
+	r.Each(func(labels Labels, d *TDigest) {
+		if d.Count() != 2000 {
+			t.Errorf("series %v has wrong count, have=%d, want=%d", labels, d.Count(), 2000)
+		}
+		if d.Compression() != 50 {
+			t.Errorf("series %v has wrong compression, have=%v, want=%v", labels, d.Compression(), 50)
+		}
+	})
+}
+
+func TestRegistrySnapshot(t *testing.T) {
+	r := NewRegistry()
+	var (
+		wg   sync.WaitGroup
+		snap = NewRegistry()
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10000; i++ {
+			r.Observe(Labels{"n": "x"}, float64(i))
+		}
+	}()
+	// Take snapshots while observations are in progress; none should be
+	// lost.
+	for i := 0; i < 10; i++ {
+		r.Snapshot().MergeInto(snap)
+	}
+	wg.Wait()
+	r.Snapshot().MergeInto(snap)
+
+	if r.Len() != 0 {
+		t.Errorf("Registry not reset by Snapshot, have %d series", r.Len())
+	}
+	var total int64
+	snap.Each(func(labels Labels, d *TDigest) {
+		total += d.Count()
+	})
+	if total != 10000 {
+		t.Errorf("snapshots lost observations, have=%d, want=%d", total, 10000)
+	}
+}
+
+func TestRegistryMergeBinary(t *testing.T) {
+	a, b := NewRegistry(), NewRegistry()
+	for i := 0; i < 1000; i++ {
+		a.Observe(Labels{"host": "a", "shared": "yes"}, float64(i))
+		b.Observe(Labels{"shared": "yes", "host": "a"}, float64(i+1000))
+		b.Observe(Labels{"host": "b"}, float64(i))
+	}
+
+	p, err := a.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary err: %v", err)
+	}
+	if err := b.MergeBinary(p); err != nil {
+		t.Fatalf("MergeBinary err: %v", err)
+	}
+
+	if b.Len() != 2 {
+		t.Fatalf("merged Registry has wrong number of series, have=%d, want=%d", b.Len(), 2)
+	}
+	b.Each(func(labels Labels, d *TDigest) {
+		switch labels["host"] {
+		case "a":
+			if d.Count() != 2000 {
+				t.Errorf("merged series has wrong count, have=%d, want=%d", d.Count(), 2000)
+			}
+			if median := d.Quantile(0.5); math.Abs(median-1000) > 50 {
+				t.Errorf("merged series has wrong median, have=%v, want about 1000", median)
+			}
+		case "b":
+			if d.Count() != 1000 {
+				t.Errorf("unmerged series has wrong count, have=%d, want=%d", d.Count(), 1000)
+			}
+		}
+	})
+}
+
+func TestRegistryMarshalBinaryConcurrent(t *testing.T) {
+	r := NewRegistry()
+	var (
+		wg   sync.WaitGroup
+		done = make(chan struct{})
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			r.Observe(Labels{"n": strconv.Itoa(i)}, float64(i))
+		}
+	}()
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+	// Marshal while new series are being added; each encoding should hold
+	// exactly the series it claims to.
+	for r.Len() < 2000 {
+		p, err := r.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary err: %v", err)
+		}
+		if err := NewRegistry().MergeBinary(p); err != nil {
+			t.Fatalf("MergeBinary err: %v", err)
+		}
+	}
+}
+
+func TestRegistryMergeBinaryErrors(t *testing.T) {
+	r := NewRegistry()
+	r.Observe(Labels{"a": "b"}, 1)
+	p, err := r.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary err: %v", err)
+	}
+
+	testcase := func(in []byte, wantErr error) func(*testing.T) {
+		return func(t *testing.T) {
+			have := NewRegistry()
+			err := have.MergeBinary(in)
+			if err == nil {
+				t.Fatalf("expected err=%q, got nil", wantErr.Error())
+			}
+			if err.Error() != wantErr.Error() {
+				t.Fatalf("wrong error, want=%q, have=%q", wantErr.Error(), err.Error())
+			}
+			if have.Len() != 0 {
+				t.Errorf("failed MergeBinary modified the registry")
+			}
+		}
+	}
+	t.Run("nil", testcase(nil, io.ErrUnexpectedEOF))
+	t.Run("tdigest", testcase(
+		[]byte{0x80, 0x0c, 0x02, 0x00, 0x00, 0x00},
+		errors.New("data corruption detected: invalid registry header magic value 0x0c80"),
+	))
+	one := New()
+	one.Add(1, 1)
+	encoded, _ := one.MarshalBinary()
+	t.Run("truncated", testcase(p[:len(p)-1], fmt.Errorf("data corruption detected: invalid tdigest size %d", len(encoded))))
+	t.Run("trailing bytes", testcase(append(p, 0), errors.New("found 1 unexpected bytes trailing the registry")))
+	t.Run("too many series", testcase(
+		[]byte{0x81, 0x0c, 0x01, 0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0x7f},
+		errors.New("data corruption detected: invalid number of series 2147483647"),
+	))
+}
@@ -0,0 +1,89 @@
+package tdigest
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// A TimedDigest is a serialized TDigest holding the data for a period of time
+// starting at Time, like one minute of request latencies.
+type TimedDigest struct {
+	Time time.Time
+	// Data is a TDigest serialized with MarshalBinary.
+	Data []byte
+}
+
+// Rollup combines fine-grained digests into coarser ones, like per-minute
+// digests into hourly or daily ones. Each digest in in is assigned to the
+// bucket of width that its Time falls in, and all of the digests in each
+// bucket are merged into one with the given compression. The rolled-up
+// digests are returned in order of time, serialized with MarshalBinary, with
+// each Time set to the start of its bucket in UTC. Empty buckets are omitted.
+//
+// Buckets start at multiples of width since the zero time, like
+// time.Time.Truncate, so daily buckets hold UTC days. The digests in in may
+// be in any order.
+//
+// Digests are merged by combining all of their centroids in order of their
+// means and then compressing the result, rather than by adding one to
+// another with MergeInto. This is faster, and the result does not depend on
+// the order of in.
+func Rollup(in []TimedDigest, width time.Duration, compression float64) ([]TimedDigest, error) {
+	if width <= 0 {
+		return nil, fmt.Errorf("rollup width must be positive, have %v", width)
+	}
+	buckets := make(map[time.Time][]*TDigest)
+	for i, td := range in {
+		d := New()
+		if err := d.UnmarshalBinary(td.Data); err != nil {
+			return nil, fmt.Errorf("digest %d at %v: %v", i, td.Time, err)
+		}
+		start := td.Time.Truncate(width).UTC()
+		buckets[start] = append(buckets[start], d)
+	}
+
+	starts := make([]time.Time, 0, len(buckets))
+	for start := range buckets {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+
+	out := make([]TimedDigest, len(starts))
+	for i, start := range starts {
+		p, err := mergeDigests(buckets[start], compression).MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		out[i] = TimedDigest{Time: start, Data: p}
+	}
+	return out, nil
+}
+
+// mergeDigests returns a new TDigest with the given compression, holding all
+// of the data in digests. Their centroids are combined in order of their
+// means, and then merged down to the compression.
+func mergeDigests(digests []*TDigest, compression float64) *TDigest {
+	var n int
+	for _, d := range digests {
+		n += len(d.centroids)
+	}
+	merged := NewWithCompression(compression)
+	merged.centroids = make([]centroid, 0, n)
+	for _, d := range digests {
+		if d.countTotal > 0 {
+			merged.updateExtremes(d.min, d.max)
+		}
+		merged.countTotal += d.countTotal
+		merged.nanCount += d.nanCount
+		merged.negInfCount += d.negInfCount
+		merged.posInfCount += d.posInfCount
+		merged.centroids = append(merged.centroids, d.centroids...)
+	}
+	sort.Slice(merged.centroids, func(i, j int) bool {
+		a, b := merged.centroids[i], merged.centroids[j]
+		return a.mean < b.mean || (a.mean == b.mean && a.count < b.count)
+	})
+	merged.mergeCentroids(compression)
+	return merged
+}
@@ -0,0 +1,118 @@
+package tdigest
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestRollup(t *testing.T) {
+	rand.Seed(rngSeed)
+	var (
+		start   = time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+		minutes []TimedDigest
+		hours   = make([][]float64, 2)
+	)
+	for m := 0; m < 120; m++ {
+		d := New()
+		for i := 0; i < 100; i++ {
+			v := rand.ExpFloat64()
+			d.Add(v, 1)
+			hours[m/60] = append(hours[m/60], v)
+		}
+		p, err := d.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary err: %v", err)
+		}
+		minutes = append(minutes, TimedDigest{Time: start.Add(time.Duration(m) * time.Minute), Data: p})
+	}
+
+	out, err := Rollup(minutes, time.Hour, 50)
+	if err != nil {
+		t.Fatalf("Rollup err: %v", err)
+	}
+	if len(out) != 2 {
+		t.Fatalf("Rollup returned wrong number of digests, have=%d, want=%d", len(out), 2)
+	}
+	for i, td := range out {
+		if want := start.Add(time.Duration(i) * time.Hour); !td.Time.Equal(want) {
+			t.Errorf("rollup %d has wrong time, have=%v, want=%v", i, td.Time, want)
+		}
+		d := New()
+		if err := d.UnmarshalBinary(td.Data); err != nil {
+			t.Fatalf("UnmarshalBinary err: %v", err)
+		}
+		if d.Compression() != 50 || len(d.centroids) > 52 {
+			t.Errorf("rollup %d not recompressed, compression=%v centroids=%d", i, d.Compression(), len(d.centroids))
+		}
+		vals := hours[i]
+		sort.Float64s(vals)
+		if d.Count() != int64(len(vals)) || d.Min() != vals[0] || d.Max() != vals[len(vals)-1] {
+			t.Errorf("rollup %d has wrong data, count=%d min=%v max=%v, want count=%d min=%v max=%v",
+				i, d.Count(), d.Min(), d.Max(), len(vals), vals[0], vals[len(vals)-1])
+		}
+		for _, q := range []float64{0.01, 0.1, 0.5, 0.9, 0.99} {
+			// Compare ranks, since values are sparse in the tail. The
+			// per-minute digests are only estimates themselves, so
+			// the bound is loose.
+			have := d.Quantile(q)
+			rank := float64(sort.SearchFloat64s(vals, have)) / float64(len(vals))
+			if math.Abs(rank-q) > 0.05 {
+				t.Errorf("rollup %d has wrong Quantile(%v), have=%v at rank %v", i, q, have, rank)
+			}
+		}
+	}
+
+	// The order of the input does not matter.
+	rand.Shuffle(len(minutes), func(i, j int) {
+		minutes[i], minutes[j] = minutes[j], minutes[i]
+	})
+	shuffled, err := Rollup(minutes, time.Hour, 50)
+	if err != nil {
+		t.Fatalf("Rollup err: %v", err)
+	}
+	for i := range out {
+		if !bytes.Equal(out[i].Data, shuffled[i].Data) {
+			t.Errorf("rollup %d depends on the order of the input", i)
+		}
+	}
+}
+
+func TestRollupNonFinite(t *testing.T) {
+	d := nonFiniteTDigest()
+	p, err := d.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary err: %v", err)
+	}
+	now := time.Now()
+	out, err := Rollup([]TimedDigest{{now, p}, {now, p}}, 24*time.Hour, 100)
+	if err != nil {
+		t.Fatalf("Rollup err: %v", err)
+	}
+	have := New()
+	if err := have.UnmarshalBinary(out[0].Data); err != nil {
+		t.Fatalf("UnmarshalBinary err: %v", err)
+	}
+	if have.Count() != 2*d.Count() || have.NaNCount() != 2*d.NaNCount() {
+		t.Errorf("rollup lost non-finite values, count=%d nan=%d", have.Count(), have.NaNCount())
+	}
+	if want := now.Truncate(24 * time.Hour).UTC(); !out[0].Time.Equal(want) || out[0].Time.Location() != time.UTC {
+		t.Errorf("rollup has wrong time, have=%v, want=%v", out[0].Time, want)
+	}
+}
+
+func TestRollupErrors(t *testing.T) {
+	if _, err := Rollup(nil, 0, 100); err == nil {
+		t.Error("expected error for zero width, got nil")
+	}
+	bad := []TimedDigest{{time.Now(), []byte{1, 2, 3}}}
+	if _, err := Rollup(bad, time.Hour, 100); err == nil {
+		t.Error("expected error for invalid digest, got nil")
+	}
+	if out, err := Rollup(nil, time.Hour, 100); err != nil || len(out) != 0 {
+		t.Errorf("Rollup of no digests wrong, have=%v err=%v", out, err)
+	}
+}
@@ -3,6 +3,7 @@ package tdigest
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"io"
 	"math"
@@ -10,15 +11,66 @@ import (
 
 const (
 	magic           = int16(0xc80)
-	encodingVersion = int32(1)
+	encodingVersion = int32(2)
+
+	// flagNonFinite is set in the flags of the binary encoding if the counts
+	// of NaN, -Inf and +Inf values follow them. It is left unset when the
+	// counts are all zero, so that such TDigests can still be decoded by
+	// older versions of this package.
+	flagNonFinite = int32(1 << 0)
+	// flagExact is set in the flags of the binary encoding if the TDigest
+	// is in exact mode.
+	flagExact = int32(1 << 1)
+
+	knownFlags = flagNonFinite | flagExact
 )
 
-func marshalBinary(d *TDigest) ([]byte, error) {
+// The binary encoding is a header made of the magic value and the encoding
+// version, followed by the compression level and, since version 2, the
+// smallest and largest values in the TDigest and a set of flags. Then comes
+// the number of centroids, and the count and mean of each one. All values are
+// little-endian.
+//
+// The flags mark optional parts of the encoding, so that they can be added
+// without changing its version. Decoders reject flags they don't know, since
+// they can't tell how to skip the parts those flags mark. If flagNonFinite is
+// set, the counts of NaN, -Inf and +Inf values follow the flags. flagExact
+// marks a TDigest in exact mode, and adds nothing to the encoding.
+//
+// When decoding version 1, which has no smallest and largest values, the means
+// of the first and last centroids are used instead.
+
+func marshalBinary(d *TDigest, version int32) ([]byte, error) {
+	if version < 1 || version > encodingVersion {
+		return nil, fmt.Errorf("unknown encoding version %d", version)
+	}
+	hasNonFinite := d.nanCount != 0 || d.negInfCount != 0 || d.posInfCount != 0
+	if version < 2 && hasNonFinite {
+		return nil, fmt.Errorf("encoding version %d cannot hold NaN or infinite values", version)
+	}
+
 	buf := bytes.NewBuffer(nil)
 	w := &binaryBufferWriter{buf: buf}
 	w.writeValue(magic)
-	w.writeValue(encodingVersion)
+	w.writeValue(version)
 	w.writeValue(d.compression)
+	if version >= 2 {
+		w.writeValue(d.min)
+		w.writeValue(d.max)
+		var flags int32
+		if hasNonFinite {
+			flags |= flagNonFinite
+		}
+		if d.exact {
+			flags |= flagExact
+		}
+		w.writeValue(flags)
+		if flags&flagNonFinite != 0 {
+			w.writeValue(d.nanCount)
+			w.writeValue(d.negInfCount)
+			w.writeValue(d.posInfCount)
+		}
+	}
 	w.writeValue(int32(len(d.centroids)))
 	for _, c := range d.centroids {
 		w.writeValue(c.count)
@@ -49,10 +101,37 @@ func unmarshalBinary(d *TDigest, p []byte) error {
 	if r.err != nil {
 		return r.err
 	}
-	if ev != encodingVersion {
+	if ev < 1 || ev > encodingVersion {
 		return fmt.Errorf("data corruption detected: invalid encoding version %d", ev)
 	}
 	r.readValue(&d.compression)
+	if r.err != nil {
+		return r.err
+	}
+	if err := d.checkCompression(); err != nil {
+		return err
+	}
+	var flags int32
+	if ev >= 2 {
+		r.readValue(&d.min)
+		r.readValue(&d.max)
+		r.readValue(&flags)
+		if r.err != nil {
+			return r.err
+		}
+		if flags&^knownFlags != 0 {
+			return fmt.Errorf("data corruption detected: unknown flags 0x%x", flags)
+		}
+	}
+	d.nanCount, d.negInfCount, d.posInfCount = 0, 0, 0
+	if flags&flagNonFinite != 0 {
+		r.readValue(&d.nanCount)
+		r.readValue(&d.negInfCount)
+		r.readValue(&d.posInfCount)
+	}
+	d.exact = flags&flagExact != 0
+	d.countTotal = 0
+	d.rejected = Rejected{}
 	r.readValue(&n)
 	if r.err != nil {
 		return r.err
@@ -64,40 +143,163 @@ func unmarshalBinary(d *TDigest, p []byte) error {
 	if n > 1<<20 {
 		return fmt.Errorf("invalid n, cannot be greater than 2^20: %v", n)
 	}
-	d.centroids = make([]*centroid, int(n))
+	d.centroids = make([]centroid, int(n))
+	d.invalidateCumulative()
 	for i := 0; i < int(n); i++ {
-		c := new(centroid)
+		var c centroid
 		r.readValue(&c.count)
 		r.readValue(&c.mean)
 		if r.err != nil {
 			return r.err
 		}
-		if c.count < 0 {
-			return fmt.Errorf("data corruption detected: negative count: %d", c.count)
-		}
-		if math.IsNaN(c.mean) {
-			return fmt.Errorf("data corruption detected: NaN mean not permitted")
+		if err := d.appendCentroid(i, c); err != nil {
+			return err
 		}
-		if math.IsInf(c.mean, 0) {
-			return fmt.Errorf("data corruption detected: Inf mean not permitted")
+	}
+
+	if n := r.r.Len(); n > 0 {
+		return fmt.Errorf("found %d unexpected bytes trailing the tdigest", n)
+	}
+	if err := d.checkNonFinite(); err != nil {
+		return err
+	}
+
+	if ev < 2 {
+		d.setExtremesFromCentroids()
+	} else if err := d.checkExtremes(); err != nil {
+		return err
+	}
+	d.enforceMaxCentroids()
+	return nil
+}
+
+// appendCentroid validates c, which has been decoded as the ith centroid of d,
+// and stores it.
+func (d *TDigest) appendCentroid(i int, c centroid) error {
+	if c.count < 0 {
+		return fmt.Errorf("data corruption detected: negative count: %d", c.count)
+	}
+	if math.IsNaN(c.mean) {
+		return fmt.Errorf("data corruption detected: NaN mean not permitted")
+	}
+	if math.IsInf(c.mean, 0) {
+		return fmt.Errorf("data corruption detected: Inf mean not permitted")
+	}
+	if i > 0 {
+		prev := d.centroids[i-1]
+		if c.mean < prev.mean {
+			return fmt.Errorf("data corruption detected: centroid %d has lower mean (%v) than preceding centroid %d (%v)", i, c.mean, i-1, prev.mean)
 		}
-		if i > 0 {
-			prev := d.centroids[i-1]
-			if c.mean < prev.mean {
-				return fmt.Errorf("data corruption detected: centroid %d has lower mean (%v) than preceding centroid %d (%v)", i, c.mean, i-1, prev.mean)
-			}
+	}
+	d.centroids[i] = c
+	if c.count > math.MaxInt64-d.countTotal {
+		return fmt.Errorf("data corruption detected: centroid total size overflow")
+	}
+	d.countTotal += c.count
+	return nil
+}
+
+// jsonTDigest is the JSON representation of a TDigest. Min and Max are
+// optional when decoding, like in version 1 of the binary encoding. The
+// counts of NaN and infinite values are left out when they are zero.
+type jsonTDigest struct {
+	Compression float64    `json:"compression"`
+	Min         *float64   `json:"min,omitempty"`
+	Max         *float64   `json:"max,omitempty"`
+	NaNCount    int64      `json:"nan_count,omitempty"`
+	NegInfCount int64      `json:"neg_inf_count,omitempty"`
+	PosInfCount int64      `json:"pos_inf_count,omitempty"`
+	Exact       bool       `json:"exact,omitempty"`
+	Centroids   []Centroid `json:"centroids"`
+}
+
+func marshalJSON(d *TDigest) ([]byte, error) {
+	return json.Marshal(jsonTDigest{
+		Compression: d.compression,
+		Min:         &d.min,
+		Max:         &d.max,
+		NaNCount:    d.nanCount,
+		NegInfCount: d.negInfCount,
+		PosInfCount: d.posInfCount,
+		Exact:       d.exact,
+		Centroids:   d.Centroids(),
+	})
+}
+
+func unmarshalJSON(d *TDigest, p []byte) error {
+	var v jsonTDigest
+	if err := json.Unmarshal(p, &v); err != nil {
+		return err
+	}
+	if len(v.Centroids) > 1<<20 {
+		return fmt.Errorf("invalid n, cannot be greater than 2^20: %v", len(v.Centroids))
+	}
+	d.compression = v.Compression
+	if err := d.checkCompression(); err != nil {
+		return err
+	}
+	d.nanCount, d.negInfCount, d.posInfCount = v.NaNCount, v.NegInfCount, v.PosInfCount
+	d.exact = v.Exact
+	d.countTotal = 0
+	d.rejected = Rejected{}
+	d.centroids = make([]centroid, len(v.Centroids))
+	d.invalidateCumulative()
+	for i, c := range v.Centroids {
+		if err := d.appendCentroid(i, centroid{mean: c.Mean, count: c.Count}); err != nil {
+			return err
 		}
-		d.centroids[i] = c
-		if c.count > math.MaxInt64-d.countTotal {
-			return fmt.Errorf("data corruption detected: centroid total size overflow")
+	}
+	if err := d.checkNonFinite(); err != nil {
+		return err
+	}
+	if v.Min == nil || v.Max == nil {
+		d.setExtremesFromCentroids()
+	} else {
+		d.min, d.max = *v.Min, *v.Max
+		if err := d.checkExtremes(); err != nil {
+			return err
 		}
-		d.countTotal += c.count
 	}
+	d.enforceMaxCentroids()
+	return nil
+}
 
-	if n := r.r.Len(); n > 0 {
-		return fmt.Errorf("found %d unexpected bytes trailing the tdigest", n)
+// setExtremesFromCentroids estimates the smallest and largest values of d,
+// when they weren't recorded, from the means of its outermost centroids.
+func (d *TDigest) setExtremesFromCentroids() {
+	d.min, d.max = 0, 0
+	if n := len(d.centroids); n > 0 {
+		d.min, d.max = d.centroids[0].mean, d.centroids[n-1].mean
+	}
+}
+
+func (d *TDigest) checkCompression() error {
+	if math.IsNaN(d.compression) || math.IsInf(d.compression, 0) || d.compression < 0 {
+		return fmt.Errorf("data corruption detected: invalid compression %v", d.compression)
+	}
+	return nil
+}
+
+func (d *TDigest) checkNonFinite() error {
+	if d.nanCount < 0 || d.negInfCount < 0 || d.posInfCount < 0 {
+		return fmt.Errorf("data corruption detected: negative NaN or Inf count")
 	}
+	if d.negInfCount > math.MaxInt64-d.countTotal || d.posInfCount > math.MaxInt64-d.countTotal-d.negInfCount {
+		return fmt.Errorf("data corruption detected: Inf count overflow")
+	}
+	return nil
+}
 
+func (d *TDigest) checkExtremes() error {
+	if math.IsNaN(d.min) || math.IsNaN(d.max) {
+		return fmt.Errorf("data corruption detected: NaN min or max not permitted")
+	}
+	if math.IsInf(d.min, 0) || math.IsInf(d.max, 0) {
+		return fmt.Errorf("data corruption detected: Inf min or max not permitted")
+	}
+	if d.min > d.max {
+		return fmt.Errorf("data corruption detected: min (%v) is greater than max (%v)", d.min, d.max)
+	}
 	return nil
 }
 
@@ -1,8 +1,12 @@
 package tdigest
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"math"
 	"reflect"
 	"testing"
 )
@@ -19,7 +23,7 @@ func TestMarshalRoundTrip(t *testing.T) {
 			if err != nil {
 				t.Fatalf("UnmarshalBinary err: %v", err)
 			}
-			if !reflect.DeepEqual(in, out) {
+			if !equalTDigests(in, out) {
 				t.Errorf("marshaling round trip resulted in changes")
 				t.Logf("in: %+v", in)
 				t.Logf("out: %+v", out)
@@ -35,6 +39,191 @@ func TestMarshalRoundTrip(t *testing.T) {
 	d.Add(1, 1)
 	d.Add(0, 1)
 	t.Run("1, 1, 0 input", testcase(d))
+
+	t.Run("non-finite values", testcase(nonFiniteTDigest()))
+	t.Run("exact", testcase(exactTDigest()))
+}
+
+func TestMarshalOmitsZeroNonFinite(t *testing.T) {
+	// Without NaN or infinite values, the counts of them are left out, so
+	// that decoders which predate them can still read the TDigest.
+	p, err := simpleTDigest(1).MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary err: %v", err)
+	}
+	if want := 2 + 4 + 3*8 + 4 + 4 + 16; len(p) != want {
+		t.Errorf("wrong encoded size, have=%d, want=%d", len(p), want)
+	}
+}
+
+func TestMarshalBinaryVersion(t *testing.T) {
+	d := simpleTDigest(100)
+	p, err := d.MarshalBinaryVersion(1)
+	if err != nil {
+		t.Fatalf("MarshalBinaryVersion err: %v", err)
+	}
+	if want := 2 + 4 + 8 + 4 + 16*len(d.centroids); len(p) != want {
+		t.Errorf("wrong encoded size, have=%d, want=%d", len(p), want)
+	}
+	have := new(TDigest)
+	if err := have.UnmarshalBinary(p); err != nil {
+		t.Fatalf("UnmarshalBinary err: %v", err)
+	}
+	if !reflect.DeepEqual(have.centroids, d.centroids) || have.compression != d.compression {
+		t.Errorf("version 1 round trip changed the centroids")
+	}
+	// Version 1 has no extremes, so they are taken from the centroids.
+	n := len(d.centroids)
+	if have.min != d.centroids[0].mean || have.max != d.centroids[n-1].mean {
+		t.Errorf("wrong extremes, have min=%v max=%v", have.min, have.max)
+	}
+
+	if p, err := d.MarshalBinaryVersion(2); err != nil {
+		t.Errorf("MarshalBinaryVersion(2) err: %v", err)
+	} else if latest, _ := d.MarshalBinary(); !bytes.Equal(p, latest) {
+		t.Errorf("version 2 differs from MarshalBinary")
+	}
+
+	for _, version := range []int{0, 3} {
+		want := fmt.Sprintf("unknown encoding version %d", version)
+		if _, err := d.MarshalBinaryVersion(version); err == nil || err.Error() != want {
+			t.Errorf("wrong error for version %d, have=%v, want=%q", version, err, want)
+		}
+	}
+	want := "encoding version 1 cannot hold NaN or infinite values"
+	if _, err := nonFiniteTDigest().MarshalBinaryVersion(1); err == nil || err.Error() != want {
+		t.Errorf("wrong error for non-finite values, have=%v, want=%q", err, want)
+	}
+}
+
+func TestMarshalJSONRoundTrip(t *testing.T) {
+	testcase := func(in *TDigest) func(*testing.T) {
+		return func(t *testing.T) {
+			b, err := json.Marshal(in)
+			if err != nil {
+				t.Fatalf("MarshalJSON err: %v", err)
+			}
+			out := new(TDigest)
+			err = json.Unmarshal(b, out)
+			if err != nil {
+				t.Fatalf("UnmarshalJSON err: %v", err)
+			}
+			if !equalTDigests(in, out) {
+				t.Errorf("marshaling round trip resulted in changes")
+				t.Logf("in: %+v", in)
+				t.Logf("out: %+v", out)
+			}
+		}
+	}
+	t.Run("empty", testcase(New()))
+	t.Run("1 value", testcase(simpleTDigest(1)))
+	t.Run("1000 values", testcase(simpleTDigest(1000)))
+	t.Run("non-finite values", testcase(nonFiniteTDigest()))
+	t.Run("exact", testcase(exactTDigest()))
+}
+
+// exactTDigest returns a TDigest in exact mode. Its threshold is not set,
+// since that is not serialized.
+func exactTDigest() *TDigest {
+	d := New(WithExactThreshold(10))
+	d.Add(1, 1)
+	d.Add(2, 3)
+	d.exactThreshold = 0
+	return d
+}
+
+func TestMarshalJSON(t *testing.T) {
+	d := tdFromWeights([]int64{1, 2})
+	have, err := d.MarshalJSON()
+	if err != nil {
+		t.Fatalf("MarshalJSON err: %v", err)
+	}
+	want := `{"compression":1,"min":0,"max":1,"centroids":[{"mean":0,"count":1},{"mean":1,"count":2}]}`
+	if string(have) != want {
+		t.Errorf("MarshalJSON wrong, have=%s, want=%s", have, want)
+	}
+}
+
+func TestUnmarshalJSONErrors(t *testing.T) {
+	testcase := func(in string, wantErr string) func(*testing.T) {
+		return func(t *testing.T) {
+			err := new(TDigest).UnmarshalJSON([]byte(in))
+			if err == nil {
+				t.Fatalf("expected err=%q, got nil", wantErr)
+			}
+			if err.Error() != wantErr {
+				t.Fatalf("wrong error, want=%q, have=%q", wantErr, err.Error())
+			}
+		}
+	}
+	t.Run("negative count", testcase(
+		`{"compression":100,"centroids":[{"mean":1,"count":-1}]}`,
+		"data corruption detected: negative count: -1",
+	))
+	t.Run("decreasing means", testcase(
+		`{"compression":100,"centroids":[{"mean":2,"count":1},{"mean":1,"count":1}]}`,
+		"data corruption detected: centroid 1 has lower mean (1) than preceding centroid 0 (2)",
+	))
+	t.Run("negative compression", testcase(
+		`{"compression":-1,"centroids":[]}`,
+		"data corruption detected: invalid compression -1",
+	))
+}
+
+func TestUnmarshalMaxCentroids(t *testing.T) {
+	b, err := simpleTDigest(1000).MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary err: %v", err)
+	}
+	d := New(WithMaxCentroids(10))
+	if err := d.UnmarshalBinary(b); err != nil {
+		t.Fatalf("UnmarshalBinary err: %v", err)
+	}
+	if len(d.centroids) > 10 {
+		t.Errorf("unmarshaled TDigest has too many centroids, have=%d, want<=%d", len(d.centroids), 10)
+	}
+	if d.countTotal != 1000 {
+		t.Errorf("unmarshaled TDigest has wrong count, have=%d, want=%d", d.countTotal, 1000)
+	}
+}
+
+func TestUnmarshalIntoUsedTDigest(t *testing.T) {
+	in := simpleTDigest(100)
+	b, err := in.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary err: %v", err)
+	}
+	j, err := in.MarshalJSON()
+	if err != nil {
+		t.Fatalf("MarshalJSON err: %v", err)
+	}
+	used := func() *TDigest {
+		d := simpleTDigest(50)
+		d.Add(math.NaN(), 1)
+		return d
+	}
+	unmarshalers := map[string]func(*TDigest) error{
+		"binary": func(d *TDigest) error { return d.UnmarshalBinary(b) },
+		"json":   func(d *TDigest) error { return d.UnmarshalJSON(j) },
+	}
+	for name, unmarshal := range unmarshalers {
+		unmarshal := unmarshal
+		t.Run(name, func(t *testing.T) {
+			d := used()
+			if err := unmarshal(d); err != nil {
+				t.Fatalf("unmarshal err: %v", err)
+			}
+			if have := d.Count(); have != 100 {
+				t.Errorf("wrong count, have=%d, want=%d", have, 100)
+			}
+			if have := d.Rejected(); have != (Rejected{}) {
+				t.Errorf("rejected inputs not reset, have=%+v", have)
+			}
+			if !equalTDigests(in, d) {
+				t.Errorf("unmarshaled TDigest differs from the marshaled one")
+			}
+		})
+	}
 }
 
 func TestUnmarshalErrors(t *testing.T) {
@@ -80,6 +269,80 @@ func TestUnmarshalErrors(t *testing.T) {
 		},
 		errors.New("data corruption detected: invalid encoding version -1"),
 	))
+	t.Run("future encoding", testcase(
+		[]byte{
+			0x80, 0x0c,
+			0x03, 0x00, 0x00, 0x00,
+		},
+		errors.New("data corruption detected: invalid encoding version 3"),
+	))
+	t.Run("min greater than max", testcase(
+		[]byte{
+			0x80, 0x0c,
+			0x02, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x59, 0x40,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x40,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xF0, 0x3F,
+			0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00,
+		},
+		errors.New("data corruption detected: min (2) is greater than max (1)"),
+	))
+	t.Run("unknown flags", testcase(
+		[]byte{
+			0x80, 0x0c,
+			0x02, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x59, 0x40,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xF0, 0x3F,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x40,
+			0x04, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00,
+		},
+		errors.New("data corruption detected: unknown flags 0x4"),
+	))
+	t.Run("nan max", testcase(
+		[]byte{
+			0x80, 0x0c,
+			0x02, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x59, 0x40,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xF0, 0x3F,
+			0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF,
+			0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00,
+		},
+		errors.New("data corruption detected: NaN min or max not permitted"),
+	))
+	t.Run("negative nan count", testcase(
+		[]byte{
+			0x80, 0x0c,
+			0x02, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x59, 0x40,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x01, 0x00, 0x00, 0x00,
+			0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00,
+		},
+		errors.New("data corruption detected: negative NaN or Inf count"),
+	))
+	t.Run("NaN compression", testcase(
+		[]byte{
+			0x80, 0x0c,
+			0x02, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xF8, 0x7F,
+		},
+		errors.New("data corruption detected: invalid compression NaN"),
+	))
+	t.Run("infinite compression", testcase(
+		[]byte{
+			0x80, 0x0c,
+			0x01, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xF0, 0x7F,
+		},
+		errors.New("data corruption detected: invalid compression +Inf"),
+	))
 	t.Run("incomplete compression", testcase(
 		[]byte{
 			0x80, 0x0c,
@@ -243,7 +506,7 @@ func TestUnmarshal(t *testing.T) {
 			0x00, 0x00, 0x00, 0x00,
 		},
 		&TDigest{
-			centroids:   make([]*centroid, 0),
+			centroids:   make([]centroid, 0),
 			compression: 100,
 			countTotal:  0,
 		},
@@ -258,14 +521,16 @@ func TestUnmarshal(t *testing.T) {
 			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xF0, 0x3F,
 		},
 		&TDigest{
-			centroids: []*centroid{
-				&centroid{
+			centroids: []centroid{
+				{
 					count: 1,
 					mean:  1,
 				},
 			},
 			compression: 100,
 			countTotal:  1,
+			min:         1,
+			max:         1,
 		},
 	))
 	t.Run("two centroids", testcase(
@@ -280,18 +545,81 @@ func TestUnmarshal(t *testing.T) {
 			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x40,
 		},
 		&TDigest{
-			centroids: []*centroid{
-				&centroid{
+			centroids: []centroid{
+				{
+					count: 1,
+					mean:  1,
+				},
+				{
+					count: 1,
+					mean:  2,
+				},
+			},
+			compression: 100,
+			countTotal:  2,
+			min:         1,
+			max:         2,
+		},
+	))
+	t.Run("version 2", testcase(
+		[]byte{
+			0x80, 0x0c,
+			0x02, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x59, 0x40,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xE0, 0x3F,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x04, 0x40,
+			0x00, 0x00, 0x00, 0x00,
+			0x02, 0x00, 0x00, 0x00,
+			0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xF0, 0x3F,
+			0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x40,
+		},
+		&TDigest{
+			centroids: []centroid{
+				{
 					count: 1,
 					mean:  1,
 				},
-				&centroid{
+				{
 					count: 1,
 					mean:  2,
 				},
 			},
 			compression: 100,
 			countTotal:  2,
+			min:         0.5,
+			max:         2.5,
+		},
+	))
+	t.Run("non-finite counts", testcase(
+		[]byte{
+			0x80, 0x0c,
+			0x02, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x59, 0x40,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xF0, 0x3F,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xF0, 0x3F,
+			0x01, 0x00, 0x00, 0x00,
+			0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x01, 0x00, 0x00, 0x00,
+			0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xF0, 0x3F,
+		},
+		&TDigest{
+			centroids: []centroid{
+				{
+					count: 1,
+					mean:  1,
+				},
+			},
+			compression: 100,
+			countTotal:  1,
+			min:         1,
+			max:         1,
+			nanCount:    3,
+			posInfCount: 2,
 		},
 	))
 }
@@ -0,0 +1,249 @@
+// Package tdhttp serves live TDigests over HTTP, for debugging.
+//
+// A Handler holds a set of named TDigests, and serves their quantiles, CDF,
+// centroids and binary encoding. It is meant to be mounted on a debug mux, in
+// the same spirit as net/http/pprof:
+//
+//	h := tdhttp.NewHandler()
+//	h.Register("api/latency", latency, &latencyMu)
+//	mux.Handle("/debug/tdigest/", http.StripPrefix("/debug/tdigest", h))
+//
+// With that setup, these endpoints are available:
+//
+//	/debug/tdigest/                              an index of the registered TDigests
+//	/debug/tdigest/quantiles?name=NAME&q=0.5,0.99  quantiles, as JSON
+//	/debug/tdigest/cdf?name=NAME&x=100,250         CDF values, as JSON
+//	/debug/tdigest/centroids?name=NAME             the TDigest's JSON encoding
+//	/debug/tdigest/binary?name=NAME                the TDigest's binary encoding
+//
+// If q is omitted, the quantiles endpoint reports the 50th, 90th, 99th and
+// 99.9th percentiles.
+package tdhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/spenczar/tdigest/v2"
+)
+
+var defaultQuantiles = []float64{0.5, 0.9, 0.99, 0.999}
+
+// A Handler serves a set of named TDigests. It is safe for concurrent use.
+type Handler struct {
+	mu      sync.RWMutex
+	digests map[string]entry
+}
+
+type entry struct {
+	d  *tdigest.TDigest
+	mu sync.Locker
+}
+
+// NewHandler returns a Handler with no TDigests registered.
+func NewHandler() *Handler {
+	return &Handler{digests: make(map[string]entry)}
+}
+
+// Register makes d available under name, replacing any TDigest already
+// registered with that name.
+//
+// TDigests are not safe for concurrent use, so if d is updated while the
+// Handler may be serving requests, mu must be the lock which guards those
+// updates. The Handler holds it only long enough to copy d. If d is never
+// updated after it is registered, mu may be nil.
+func (h *Handler) Register(name string, d *tdigest.TDigest, mu sync.Locker) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.digests[name] = entry{d: d, mu: mu}
+}
+
+// Unregister removes the TDigest registered under name, if there is one.
+func (h *Handler) Unregister(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.digests, name)
+}
+
+// names returns the names of all registered TDigests, in sorted order.
+func (h *Handler) names() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	names := make([]string, 0, len(h.digests))
+	for name := range h.digests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// snapshot returns a copy of the TDigest registered under name, and its
+// binary encoding.
+func (h *Handler) snapshot(name string) (*tdigest.TDigest, []byte, error) {
+	h.mu.RLock()
+	e, ok := h.digests[name]
+	h.mu.RUnlock()
+	if !ok {
+		return nil, nil, errNotFound
+	}
+
+	if e.mu != nil {
+		e.mu.Lock()
+	}
+	d := e.d.Clone()
+	if e.mu != nil {
+		e.mu.Unlock()
+	}
+
+	p, err := d.MarshalBinary()
+	if err != nil {
+		return nil, nil, err
+	}
+	return d, p, nil
+}
+
+var errNotFound = errors.New("no such tdigest")
+
+// ServeHTTP serves the endpoint named by the last element of the request's
+// path.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	endpoint := ""
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		endpoint = path.Base(r.URL.Path)
+	}
+	if endpoint == "" {
+		h.serveIndex(w, r)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	d, p, err := h.snapshot(name)
+	if err == errNotFound {
+		http.Error(w, fmt.Sprintf("no tdigest named %q", name), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch endpoint {
+	case "quantiles":
+		qs, err := parseFloats(r.URL.Query().Get("q"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(qs) == 0 {
+			qs = defaultQuantiles
+		}
+		values := make(map[string]float64, len(qs))
+		for _, q := range qs {
+			if !(q >= 0 && q <= 1) {
+				http.Error(w, fmt.Sprintf("invalid quantile %v: must be between 0 and 1", q), http.StatusBadRequest)
+				return
+			}
+			if d.Count() > 0 {
+				values[strconv.FormatFloat(q, 'g', -1, 64)] = d.Quantile(q)
+			}
+		}
+		writeJSON(w, map[string]interface{}{
+			"name":      name,
+			"count":     d.Count(),
+			"quantiles": values,
+		})
+	case "cdf":
+		xs, err := parseFloats(r.URL.Query().Get("x"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		values := make(map[string]float64, len(xs))
+		for _, x := range xs {
+			if math.IsNaN(x) {
+				http.Error(w, "invalid value NaN: must be a number", http.StatusBadRequest)
+				return
+			}
+			if d.Count() > 0 {
+				values[strconv.FormatFloat(x, 'g', -1, 64)] = d.CDF(x)
+			}
+		}
+		writeJSON(w, map[string]interface{}{
+			"name":  name,
+			"count": d.Count(),
+			"cdf":   values,
+		})
+	case "centroids":
+		writeJSON(w, d)
+	case "binary":
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(name)+".tdigest"))
+		w.Write(p)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head><title>tdigests</title></head>
+<body>
+<h1>tdigests</h1>
+<table>
+{{range .}}<tr>
+<td>{{.}}</td>
+<td><a href="quantiles?name={{.}}">quantiles</a></td>
+<td><a href="centroids?name={{.}}">centroids</a></td>
+<td><a href="binary?name={{.}}">binary</a></td>
+</tr>
+{{else}}<tr><td>No tdigests are registered.</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func (h *Handler) serveIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := indexTemplate.Execute(w, h.names()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func parseFloats(s string) ([]float64, error) {
+	var vals []float64
+	for _, field := range strings.Split(s, ",") {
+		if field == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", field)
+		}
+		vals = append(vals, v)
+	}
+	return vals, nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,156 @@
+package tdhttp
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spenczar/tdigest/v2"
+)
+
+func newTestServer(t *testing.T) (*Handler, *httptest.Server) {
+	h := NewHandler()
+	mux := http.NewServeMux()
+	mux.Handle("/debug/tdigest/", http.StripPrefix("/debug/tdigest", h))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return h, srv
+}
+
+func get(t *testing.T, url string) (*http.Response, []byte) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s err: %v", url, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("GET %s err: %v", url, err)
+	}
+	return resp, body
+}
+
+func TestHandler(t *testing.T) {
+	h, srv := newTestServer(t)
+	d := tdigest.New()
+	for i := 0; i < 1000; i++ {
+		d.Add(float64(i), 1)
+	}
+	h.Register("api/latency", d, nil)
+	base := srv.URL + "/debug/tdigest/"
+
+	t.Run("index", func(t *testing.T) {
+		resp, body := get(t, base)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("wrong status %d", resp.StatusCode)
+		}
+		if !strings.Contains(string(body), `href="quantiles?name=api%2flatency"`) {
+			t.Errorf("index missing link to digest:\n%s", body)
+		}
+	})
+
+	t.Run("quantiles", func(t *testing.T) {
+		resp, body := get(t, base+"quantiles?name=api/latency&q=0.5,0.99")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("wrong status %d: %s", resp.StatusCode, body)
+		}
+		var have struct {
+			Name      string
+			Count     int64
+			Quantiles map[string]float64
+		}
+		if err := json.Unmarshal(body, &have); err != nil {
+			t.Fatalf("invalid json %s: %v", body, err)
+		}
+		if have.Name != "api/latency" || have.Count != 1000 || len(have.Quantiles) != 2 {
+			t.Errorf("wrong response: %s", body)
+		}
+		if p50 := have.Quantiles["0.5"]; p50 < 450 || p50 > 550 {
+			t.Errorf("wrong median, have=%v, want about 500", p50)
+		}
+	})
+
+	t.Run("cdf", func(t *testing.T) {
+		_, body := get(t, base+"cdf?name=api/latency&x=250")
+		var have struct{ CDF map[string]float64 }
+		if err := json.Unmarshal(body, &have); err != nil {
+			t.Fatalf("invalid json %s: %v", body, err)
+		}
+		if cdf := have.CDF["250"]; cdf < 0.2 || cdf > 0.3 {
+			t.Errorf("wrong cdf(250), have=%v, want about 0.25", cdf)
+		}
+	})
+
+	t.Run("centroids", func(t *testing.T) {
+		_, body := get(t, base+"centroids?name=api/latency")
+		have := tdigest.New()
+		if err := json.Unmarshal(body, have); err != nil {
+			t.Fatalf("invalid centroids %s: %v", body, err)
+		}
+		if have.Count() != 1000 {
+			t.Errorf("wrong count, have=%d, want=%d", have.Count(), 1000)
+		}
+	})
+
+	t.Run("binary", func(t *testing.T) {
+		resp, body := get(t, base+"binary?name=api/latency")
+		if ct := resp.Header.Get("Content-Type"); ct != "application/octet-stream" {
+			t.Errorf("wrong content type %q", ct)
+		}
+		have := tdigest.New()
+		if err := have.UnmarshalBinary(body); err != nil {
+			t.Fatalf("invalid binary encoding: %v", err)
+		}
+		if have.Count() != 1000 {
+			t.Errorf("wrong count, have=%d, want=%d", have.Count(), 1000)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		testcase := func(path string, want int) {
+			if resp, _ := get(t, base+path); resp.StatusCode != want {
+				t.Errorf("GET %s: wrong status, have=%d, want=%d", path, resp.StatusCode, want)
+			}
+		}
+		testcase("quantiles?name=missing", http.StatusNotFound)
+		testcase("quantiles?name=api/latency&q=2", http.StatusBadRequest)
+		testcase("quantiles?name=api/latency&q=x", http.StatusBadRequest)
+		testcase("quantiles?name=api/latency&q=NaN", http.StatusBadRequest)
+		testcase("cdf?name=api/latency&x=NaN", http.StatusBadRequest)
+		testcase("bogus?name=api/latency", http.StatusNotFound)
+
+		h.Unregister("api/latency")
+		testcase("quantiles?name=api/latency", http.StatusNotFound)
+	})
+}
+
+func TestHandlerConcurrentUpdates(t *testing.T) {
+	h, srv := newTestServer(t)
+	var (
+		mu sync.Mutex
+		d  = tdigest.New()
+		wg sync.WaitGroup
+	)
+	h.Register("live", d, &mu)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10000; i++ {
+			mu.Lock()
+			d.Add(float64(i), 1)
+			mu.Unlock()
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		if resp, body := get(t, srv.URL+"/debug/tdigest/quantiles?name=live"); resp.StatusCode != http.StatusOK {
+			t.Fatalf("wrong status %d: %s", resp.StatusCode, body)
+		}
+	}
+	wg.Wait()
+}
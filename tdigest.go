@@ -1,9 +1,12 @@
 package tdigest
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"math/rand"
+	"sort"
+	"unsafe"
 )
 
 // centroid is a simple container for a mean,count pair.
@@ -12,22 +15,105 @@ type centroid struct {
 	count int64
 }
 
-func (c *centroid) String() string {
+func (c centroid) String() string {
 	return fmt.Sprintf("c{%f x%d}", c.mean, c.count)
 }
 
+// A Centroid summarizes a cluster of nearby values held by a TDigest: how
+// many values there are, and their mean.
+type Centroid struct {
+	Mean  float64 `json:"mean"`
+	Count int64   `json:"count"`
+}
+
 // A TDigest is an efficient data structure for computing streaming approximate
 // quantiles of a dataset.
+//
+// A TDigest is not safe for concurrent use, even by several goroutines which
+// only query it: queries cache intermediate results inside the TDigest. A
+// TDigest shared between goroutines must be guarded by an exclusive lock, like
+// a sync.Mutex, for queries as well as updates; the read lock of a
+// sync.RWMutex is not enough.
 type TDigest struct {
-	centroids   []*centroid
-	compression float64
-	countTotal  int64
+	centroids    []centroid
+	compression  float64
+	countTotal   int64
+	min, max     float64
+	maxCentroids int
+
+	// countNonFinite is set by WithNonFinite. The counts of NaN and
+	// infinite values are kept apart from the centroids, which only hold
+	// finite values.
+	countNonFinite                     bool
+	nanCount, negInfCount, posInfCount int64
+
+	// rejected counts the inputs which Add and TryAdd have refused.
+	rejected Rejected
+
+	// exact is set while d is in exact mode. See WithExactThreshold.
+	exact          bool
+	exactThreshold int64
+	quantileMethod QuantileMethod
+
+	interpolation Interpolation
+
+	// cumulative is a Fenwick tree over the counts of the centroids, which
+	// gives the total count of the centroids before any one of them in
+	// logarithmic time. It is built lazily by updateCumulative, and is only
+	// valid while it holds one more element than centroids. Changes to the
+	// count of a centroid must be applied to it with addCumulative; anything
+	// else which changes the centroids must call invalidateCumulative.
+	cumulative []int64
+}
+
+// An Option configures a TDigest when it is created.
+type Option func(*TDigest)
+
+// WithCompression sets the compression level of a TDigest. See
+// NewWithCompression for details.
+func WithCompression(compression float64) Option {
+	return func(d *TDigest) {
+		d.compression = compression
+	}
+}
+
+// WithMaxCentroids puts a hard limit on the number of centroids a TDigest will
+// hold, which bounds its memory use regardless of the input data. Whenever an
+// addition would exceed the limit, the TDigest's centroids are merged down to
+// about half of it, trading away some precision. A limit of 0 means no limit.
+//
+// The limit is not part of the serialized form of a TDigest, but it is
+// enforced when data is unmarshaled into a TDigest which already has one.
+func WithMaxCentroids(n int) Option {
+	return func(d *TDigest) {
+		d.maxCentroids = n
+	}
+}
+
+// WithNonFinite makes a TDigest count NaN and infinite values passed to Add,
+// rather than ignoring them. NaNs are counted separately, and reported by
+// NaNCount. Infinite values are counted as dedicated tails at each end of the
+// distribution: they are included in Count, Quantile returns -Inf or +Inf for
+// ranks which fall within them, and CDF accounts for them.
+//
+// The counts are kept by MergeInto, Subtract and serialization whether or not
+// the TDigest receiving them was created with this option, which only
+// changes the behavior of Add. The option itself is not part of the
+// serialized form of a TDigest.
+func WithNonFinite() Option {
+	return func(d *TDigest) {
+		d.countNonFinite = true
+	}
 }
 
 // New produces a new TDigest using the default compression level of
-// 100.
-func New() *TDigest {
-	return NewWithCompression(100)
+// 100, configured with any provided options.
+func New(opts ...Option) *TDigest {
+	d := NewWithCompression(100)
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
 }
 
 // NewWithCompression produces a new TDigest with a specific
@@ -42,7 +128,7 @@ func New() *TDigest {
 // data sets (1 millionish datapoints).
 func NewWithCompression(compression float64) *TDigest {
 	return &TDigest{
-		centroids:   make([]*centroid, 0),
+		centroids:   make([]centroid, 0),
 		compression: compression,
 		countTotal:  0,
 	}
@@ -50,36 +136,33 @@ func NewWithCompression(compression float64) *TDigest {
 
 // Find the indexes of centroids which have the minimum distance to the
 // input value.
-//
-// TODO: Use a better data structure to avoid this loop.
 func (d *TDigest) nearest(val float64) []int {
-	var (
-		nearestDist float64 = math.Inf(+1)
-		thisDist    float64
-		delta       float64
-		result      []int = make([]int, 0)
-	)
-	for i, c := range d.centroids {
-		thisDist = val - c.mean
-		if thisDist < 0 {
-			thisDist *= -1
-		}
+	var n = len(d.centroids)
+	if n == 0 {
+		return []int{}
+	}
+	// Since d.centroids is sorted by mean, the nearest centroids are either
+	// side of the first one with a mean at or above val. Centroids tied for
+	// nearest form runs on each side.
+	i := sort.Search(n, func(i int) bool { return d.centroids[i].mean >= val })
+	nearestDist := math.Inf(+1)
+	if i > 0 {
+		nearestDist = val - d.centroids[i-1].mean
+	}
+	if i < n && d.centroids[i].mean-val < nearestDist {
+		nearestDist = d.centroids[i].mean - val
+	}
 
-		delta = thisDist - nearestDist
-		switch {
-		case delta < 0:
-			// we have a new winner!
-			nearestDist = thisDist
-			result = result[0:0] // wipe result
-			result = append(result, i)
-		case delta == 0:
-			// we have a tie
-			result = append(result, i)
-		default:
-			// Since d.centroids is sorted by mean, this means we
-			// have passed the best spot, so we may as well break
-			break
-		}
+	lo, hi := i, i
+	for lo > 0 && val-d.centroids[lo-1].mean == nearestDist {
+		lo--
+	}
+	for hi < n && d.centroids[hi].mean-val == nearestDist {
+		hi++
+	}
+	result := make([]int, 0, hi-lo)
+	for j := lo; j < hi; j++ {
+		result = append(result, j)
 	}
 	return result
 }
@@ -207,43 +290,131 @@ func (d *TDigest) findAddTarget(val float64) int {
 }
 
 func (d *TDigest) addNewCentroid(mean float64, weight int64) {
-	var idx int = len(d.centroids)
+	// add in sorted order, after any centroids with the same mean
+	idx := sort.Search(len(d.centroids), func(i int) bool { return d.centroids[i].mean > mean })
 
-	for i, c := range d.centroids {
-		// add in sorted order
-		if mean < c.mean {
-			idx = i
-			break
-		}
-	}
-
-	d.centroids = append(d.centroids, nil)
+	d.centroids = append(d.centroids, centroid{})
 	copy(d.centroids[idx+1:], d.centroids[idx:])
-	d.centroids[idx] = &centroid{mean, weight}
+	d.centroids[idx] = centroid{mean, weight}
+	d.invalidateCumulative()
 }
 
 // Add will add a value to the TDigest, updating all quantiles. A
 // weight can be specified; use weight of 1 if you don't care about
 // weighting your dataset.
 //
-// Add will ignore input values of NaN or Inf.
+// Add will ignore weights which are not positive, and input values of NaN or
+// Inf unless d was created with WithNonFinite. Ignored inputs are counted,
+// and reported by Rejected; use TryAdd to find out about them directly.
 func (d *TDigest) Add(val float64, weight int) {
-	if math.IsNaN(val) || math.IsInf(val, 0) {
-		return
+	_ = d.TryAdd(val, weight)
+}
+
+// TryAdd is like Add, but returns an *InputError, leaving d's data
+// unchanged, if val or weight cannot be added.
+func (d *TDigest) TryAdd(val float64, weight int) error {
+	var err error
+	switch {
+	case weight <= 0:
+		d.rejected.Weight++
+		err = ErrWeight
+	case math.IsNaN(val) || math.IsInf(val, 0):
+		if d.countNonFinite {
+			d.addNonFinite(val, int64(weight))
+			return nil
+		}
+		if math.IsNaN(val) {
+			d.rejected.NaN++
+			err = ErrNaN
+		} else {
+			d.rejected.Inf++
+			err = ErrInf
+		}
+	default:
+		d.updateExtremes(val, val)
+		d.add(val, int64(weight))
+		return nil
+	}
+	return &InputError{Value: val, Weight: weight, Err: err}
+}
+
+// Errors describing why an input was rejected by TryAdd. They are wrapped in
+// an *InputError.
+var (
+	ErrNaN    = errors.New("value is NaN")
+	ErrInf    = errors.New("value is infinite")
+	ErrWeight = errors.New("weight is not positive")
+)
+
+// An InputError is returned by TryAdd for a value or weight which cannot be
+// added to a TDigest.
+type InputError struct {
+	Value  float64
+	Weight int
+	Err    error // ErrNaN, ErrInf or ErrWeight
+}
+
+func (e *InputError) Error() string {
+	return fmt.Sprintf("tdigest: cannot add %v with weight %d: %v", e.Value, e.Weight, e.Err)
+}
+
+func (e *InputError) Unwrap() error {
+	return e.Err
+}
+
+// Rejected holds counts of the inputs which a TDigest has refused to add,
+// grouped by the reason for refusing them. Each call to Add or TryAdd counts
+// once, whatever its weight.
+type Rejected struct {
+	NaN    int64 // NaN values
+	Inf    int64 // infinite values
+	Weight int64 // weights which are not positive
+}
+
+// Rejected returns counts of the inputs that Add and TryAdd have refused to
+// add to d since it was created or last Reset. They are not part of the
+// serialized form of d, and are not carried over by MergeInto.
+func (d *TDigest) Rejected() Rejected {
+	return d.rejected
+}
+
+// addNonFinite counts weight copies of val, which is NaN or infinite.
+func (d *TDigest) addNonFinite(val float64, weight int64) {
+	switch {
+	case math.IsNaN(val):
+		d.nanCount += weight
+	case val < 0:
+		d.negInfCount += weight
+	default:
+		d.posInfCount += weight
+	}
+}
+
+// updateExtremes widens the range of values d has seen to include [lo, hi].
+func (d *TDigest) updateExtremes(lo, hi float64) {
+	if d.countTotal == 0 || lo < d.min {
+		d.min = lo
+	}
+	if d.countTotal == 0 || hi > d.max {
+		d.max = hi
 	}
-	d.add(val, int64(weight))
 }
 
 func (d *TDigest) add(val float64, weight int64) {
+	if d.exact {
+		d.addExact(val, weight)
+		return
+	}
 	d.countTotal += weight
 	var idx = d.findAddTarget(val)
 
 	if idx == -1 {
 		d.addNewCentroid(val, weight)
+		d.enforceMaxCentroids()
 		return
 	}
 
-	c := d.centroids[idx]
+	c := &d.centroids[idx]
 
 	limit := d.weightLimit(idx)
 	// how much weight will we be adding?
@@ -259,21 +430,150 @@ func (d *TDigest) add(val float64, weight int64) {
 
 		c.count += add
 		c.mean = c.mean + float64(add)*(val-c.mean)/float64(c.count)
+		d.addCumulative(idx, add)
 
+		// the remainder will be counted again by the recursive call
+		d.countTotal -= remainder
 		d.add(val, remainder)
 	} else {
 		c.count += weight
 		c.mean = c.mean + float64(weight)*(val-c.mean)/float64(c.count)
+		d.addCumulative(idx, weight)
 	}
 }
 
+// merges centroids together if d holds more than its maximum number of them.
+func (d *TDigest) enforceMaxCentroids() {
+	if d.maxCentroids <= 0 || len(d.centroids) <= d.maxCentroids {
+		return
+	}
+	// Merge down to half the limit, so that the cost of merging is amortized
+	// over many additions. mergeCentroids leaves at most compression+2
+	// centroids.
+	compression := float64(d.maxCentroids)/2 - 1
+	if compression < 0 {
+		compression = 0
+	}
+	d.mergeCentroids(compression)
+}
+
+// Reset removes all data from d, leaving it empty but configured as it was
+// when created. Its memory is kept for reuse.
+func (d *TDigest) Reset() {
+	d.centroids = d.centroids[:0]
+	d.invalidateCumulative()
+	d.countTotal = 0
+	d.min, d.max = 0, 0
+	d.nanCount, d.negInfCount, d.posInfCount = 0, 0, 0
+	d.rejected = Rejected{}
+	d.exact = d.exactThreshold > 0
+}
+
+// emptyCopy returns a new, empty TDigest configured like d.
+func (d *TDigest) emptyCopy() *TDigest {
+	e := *d
+	e.centroids = make([]centroid, 0)
+	e.cumulative = nil
+	e.Reset()
+	return &e
+}
+
+// Clone returns a copy of d, with the same configuration and data, which
+// shares no memory with it.
+func (d *TDigest) Clone() *TDigest {
+	c := *d
+	c.centroids = make([]centroid, len(d.centroids))
+	copy(c.centroids, d.centroids)
+	c.cumulative = nil
+	return &c
+}
+
+// SizeBytes estimates the number of bytes of memory used by d, including the
+// cumulative counts which queries build and cache.
+func (d *TDigest) SizeBytes() int {
+	var (
+		header      = unsafe.Sizeof(*d)
+		perCentroid = unsafe.Sizeof(centroid{})
+		perCount    = unsafe.Sizeof(int64(0))
+	)
+	return int(header) + cap(d.centroids)*int(perCentroid) + cap(d.cumulative)*int(perCount)
+}
+
+// updateCumulative builds d.cumulative if it is not valid.
+func (d *TDigest) updateCumulative() {
+	var n = len(d.centroids)
+	if len(d.cumulative) == n+1 {
+		return
+	}
+	// The tree is 1-indexed: element i holds the total count of the
+	// centroids in (i - i&-i, i].
+	d.cumulative = append(d.cumulative[:0], 0)
+	for _, c := range d.centroids {
+		d.cumulative = append(d.cumulative, c.count)
+	}
+	for i := 1; i <= n; i++ {
+		if j := i + i&-i; j <= n {
+			d.cumulative[j] += d.cumulative[i]
+		}
+	}
+}
+
+// addCumulative records that the count of the centroid at idx has grown by
+// delta.
+func (d *TDigest) addCumulative(idx int, delta int64) {
+	if len(d.cumulative) != len(d.centroids)+1 {
+		// The tree will be built from scratch when it is next needed.
+		return
+	}
+	for i := idx + 1; i < len(d.cumulative); i += i & -i {
+		d.cumulative[i] += delta
+	}
+}
+
+// countBefore returns the total count of the centroids before idx.
+func (d *TDigest) countBefore(idx int) int64 {
+	d.updateCumulative()
+	var total int64
+	for i := idx; i > 0; i -= i & -i {
+		total += d.cumulative[i]
+	}
+	return total
+}
+
+// searchCumulative finds the first centroid whose cumulative count, including
+// its own, is at least rank. It returns the centroid's index and the total
+// count of the centroids before it. If there is no such centroid, the index
+// is len(d.centroids).
+func (d *TDigest) searchCumulative(rank float64) (int, int64) {
+	d.updateCumulative()
+	var (
+		n     = len(d.centroids)
+		pos   int
+		total int64
+	)
+	step := 1
+	for step*2 <= n {
+		step *= 2
+	}
+	for ; step > 0; step /= 2 {
+		if next := pos + step; next <= n && float64(total+d.cumulative[next]) < rank {
+			pos = next
+			total += d.cumulative[next]
+		}
+	}
+	return pos, total
+}
+
+// invalidateCumulative marks d.cumulative as stale, so that it is recomputed
+// when next needed.
+func (d *TDigest) invalidateCumulative() {
+	d.cumulative = d.cumulative[:0]
+}
+
 // returns the approximate quantile that a particular centroid
 // represents
 func (d *TDigest) quantileOf(idx int) float64 {
-	var total int64
-	for _, c := range d.centroids[:idx] {
-		total += c.count
-	}
+	total := d.countBefore(idx)
 	return (float64(d.centroids[idx].count/2) + float64(total)) / float64(d.countTotal)
 }
 
@@ -281,64 +581,284 @@ func (d *TDigest) quantileOf(idx int) float64 {
 // value of q should be in the range [0.0, 1.0]; if it is outside that range, it
 // will be clipped into it automatically.
 //
+// If d is in exact mode (see WithExactThreshold), Quantile is exact.
+// Otherwise, it interpolates between centroids as set by WithInterpolation.
+//
 // Calling Quantile on a TDigest with no data will return NaN.
 func (d *TDigest) Quantile(q float64) float64 {
+	if d.negInfCount == 0 && d.posInfCount == 0 {
+		return d.quantile(q)
+	}
+	if q < 0 {
+		q = 0
+	} else if q > 1 {
+		q = 1
+	}
+	var (
+		total = float64(d.Count())
+		rank  = q * total
+	)
+	switch {
+	case rank < float64(d.negInfCount) || (d.countTotal == 0 && d.posInfCount == 0):
+		return math.Inf(-1)
+	case rank > total-float64(d.posInfCount) || d.countTotal == 0:
+		return math.Inf(+1)
+	}
+	return d.quantile((rank - float64(d.negInfCount)) / float64(d.countTotal))
+}
+
+// quantile estimates the qth quantile of the finite values in d, which are
+// held in its centroids.
+func (d *TDigest) quantile(q float64) float64 {
+	// The means of the outermost centroids can stray a little beyond the
+	// smallest and largest values through rounding, or further in
+	// corrupted data. Clamp to them, so that quantiles never decrease.
+	return math.Max(d.min, math.Min(d.unclampedQuantile(q), d.max))
+}
+
+func (d *TDigest) unclampedQuantile(q float64) float64 {
 	var n = len(d.centroids)
 	if n == 0 {
 		return math.NaN()
 	}
-	if n == 1 {
-		return d.centroids[0].mean
+	switch {
+	case d.exact:
+		return d.sampleQuantile(q, d.quantileMethod)
+	case d.interpolation == Linear:
+		return d.sampleQuantile(q, R7)
+	case d.interpolation == NearestRank:
+		return d.sampleQuantile(q, R1)
 	}
-
 	if q < 0 {
 		q = 0
 	} else if q > 1 {
 		q = 1
 	}
 
-	// rescale into count units instead of 0 to 1 units
-	q = float64(d.countTotal) * q
-	// find the first centroid which straddles q
 	var (
-		qTotal float64 = 0
-		i      int
+		total = float64(d.countTotal)
+		// index is q rescaled into count units instead of 0 to 1 units
+		index = q * total
+		first = d.centroids[0]
+		last  = d.centroids[n-1]
 	)
-	for i = 0; i < n && float64(d.centroids[i].count)/2+qTotal < q; i++ {
-		qTotal += float64(d.centroids[i].count)
+
+	// The smallest and largest values are known exactly, and are each
+	// taken to be a single sample at the edge of the outermost centroids,
+	// sitting at the middle of the first and last units of rank.
+	// Interpolate between them and the middles of those centroids. An
+	// outermost centroid with a weight of 1 is that sample already, so
+	// there is nothing to interpolate.
+	if index < 0.5 {
+		return d.min
+	}
+	if first.count > 1 && index < float64(first.count)/2 {
+		v := d.min + (index-0.5)/(float64(first.count)/2-0.5)*(first.mean-d.min)
+		return math.Min(v, first.mean)
+	}
+	if index > total-0.5 {
+		return d.max
+	}
+	if last.count > 1 && total-index <= float64(last.count)/2 {
+		v := d.max - (total-index-0.5)/(float64(last.count)/2-0.5)*(d.max-last.mean)
+		return math.Max(v, last.mean)
 	}
 
+	// find the centroids which straddle index: c1 is the first one whose
+	// middle is above it. That is either the first centroid which reaches
+	// index, or the one after it.
+	i, before := d.searchCumulative(index)
+	if i < n && float64(before)+float64(d.centroids[i].count)/2 <= index {
+		before += d.centroids[i].count
+		i++
+	}
+	// i is only out of range with centroids that have no weight
 	if i == 0 {
-		// special case 1: the targeted quantile is before the
-		// left-most centroid. extrapolate from the slope from
-		// centroid0 to centroid1.
-		c0 := d.centroids[0]
-		c1 := d.centroids[1]
-		slope := (c1.mean - c0.mean) / (float64(c1.count)/2 + float64(c0.count)/2)
-		deltaQ := q - float64(c0.count)/2 // this is negative
-		return c0.mean + slope*deltaQ
+		return first.mean
 	}
 	if i == n {
-		// special case 2: the targeted quantile is from the
-		// right-most centroid. extrapolate from the slope at the
-		// right edge.
-		c0 := d.centroids[n-2]
-		c1 := d.centroids[n-1]
-		slope := (c1.mean - c0.mean) / (float64(c1.count)/2 + float64(c0.count)/2)
-		deltaQ := q - (qTotal - float64(c1.count)/2)
-		return c1.mean + slope*deltaQ
-	}
-	// common case: targeted quantile is between 2 centroids
-	c0 := d.centroids[i-1]
-	c1 := d.centroids[i]
-	slope := (c1.mean - c0.mean) / (float64(c1.count)/2 + float64(c0.count)/2)
-	deltaQ := q - (float64(c1.count)/2 + qTotal)
-	return c1.mean + slope*deltaQ
+		return last.mean
+	}
+	var (
+		c0, c1 = d.centroids[i-1], d.centroids[i]
+		mid0   = float64(before) - float64(c0.count)/2
+		mid1   = float64(before) + float64(c1.count)/2
+	)
+
+	// A centroid with a weight of 1 is a single sample, which sits exactly
+	// at the middle of its unit of rank, so it needs no special case here:
+	// between two singletons, this interpolates between adjacent samples.
+	// Interpolate as a fraction of the fixed width between the two
+	// centroids, so that rounding can't make the result decrease as index
+	// grows, even with counts too large to be held exactly.
+	v := c0.mean + (c1.mean-c0.mean)*((index-mid0)/(mid1-mid0))
+	return math.Max(c0.mean, math.Min(v, c1.mean))
+}
+
+// CDF(x) will estimate the fraction of the dataset which is less than or
+// equal to x. It is the inverse of Quantile, interpolating between centroids
+// in the same way, and its result is in the range [0.0, 1.0].
+//
+// If d is in exact mode (see WithExactThreshold), CDF is exact.
+//
+// Calling CDF on a TDigest with no data, or with an x of NaN, will return NaN.
+func (d *TDigest) CDF(x float64) float64 {
+	if d.negInfCount == 0 && d.posInfCount == 0 {
+		return d.cdf(x)
+	}
+	if math.IsNaN(x) {
+		return math.NaN()
+	}
+	if math.IsInf(x, +1) {
+		return 1
+	}
+	rank := float64(d.negInfCount)
+	if d.countTotal > 0 {
+		rank += d.cdf(x) * float64(d.countTotal)
+	}
+	return rank / float64(d.Count())
+}
+
+// cdf estimates the fraction of the finite values in d, which are held in its
+// centroids, which are less than or equal to x.
+func (d *TDigest) cdf(x float64) float64 {
+	var n = len(d.centroids)
+	if n == 0 || math.IsNaN(x) {
+		return math.NaN()
+	}
+	switch {
+	case d.exact, d.interpolation == NearestRank:
+		return d.sampleCDF(x)
+	case d.interpolation == Linear:
+		return d.linearCDF(x)
+	}
+	var (
+		total = float64(d.countTotal)
+		first = d.centroids[0]
+		last  = d.centroids[n-1]
+	)
+	switch {
+	case x < d.min:
+		return 0
+	case x > d.max:
+		return 1
+	case n == 1:
+		if d.max == d.min {
+			return 0.5
+		}
+		return (x - d.min) / (d.max - d.min)
+	case x < first.mean:
+		// x is between the smallest value and the middle of the first
+		// centroid, which Quantile interpolates between. A first centroid
+		// with a weight of 1 is a single sample, which Quantile does not
+		// interpolate towards, so the CDF is flat up to it.
+		if x == d.min || first.count == 1 {
+			return 0.5 / total
+		}
+		return (0.5 + (x-d.min)/(first.mean-d.min)*(float64(first.count)/2-0.5)) / total
+	case x > last.mean:
+		if x == d.max || last.count == 1 {
+			return 1 - 0.5/total
+		}
+		return 1 - (0.5+(d.max-x)/(d.max-last.mean)*(float64(last.count)/2-0.5))/total
+	}
+
+	// find the first centroid which is at or above x
+	i := sort.Search(n, func(i int) bool { return d.centroids[i].mean >= x })
+	if d.centroids[i].mean == x {
+		// x is exactly at one or more centroids: treat them as one, and
+		// take the rank of its middle.
+		j := i + sort.Search(n-i, func(j int) bool { return d.centroids[i+j].mean > x })
+		lo, hi := float64(d.countBefore(i)), float64(d.countBefore(j))
+		return (lo + hi) / 2 / total
+	}
+
+	// x is between the middles of two centroids, which Quantile
+	// interpolates between.
+	var (
+		c0, c1 = d.centroids[i-1], d.centroids[i]
+		before = float64(d.countBefore(i - 1))
+		width  = float64(c0.count)/2 + float64(c1.count)/2
+		rank   = before + float64(c0.count)/2 + width*(x-c0.mean)/(c1.mean-c0.mean)
+	)
+	return rank / total
+}
+
+// Count returns the total weight of all data added to d. NaN values counted
+// because of WithNonFinite are not included; see NaNCount.
+func (d *TDigest) Count() int64 {
+	return d.countTotal + d.negInfCount + d.posInfCount
+}
+
+// NaNCount returns the total weight of NaN values added to d. It is always 0
+// unless d was created with WithNonFinite, or data holding NaNs was merged or
+// unmarshaled into it.
+func (d *TDigest) NaNCount() int64 {
+	return d.nanCount
+}
+
+// InfCounts returns the total weights of negative and positive infinite
+// values added to d, which are included in Count. Like NaNCount, they are 0
+// unless d was created with WithNonFinite, or data holding infinities was
+// merged or unmarshaled into it.
+func (d *TDigest) InfCounts() (neg, pos int64) {
+	return d.negInfCount, d.posInfCount
+}
+
+// Min returns the smallest value added to d, or NaN if d is empty.
+func (d *TDigest) Min() float64 {
+	switch {
+	case d.negInfCount > 0:
+		return math.Inf(-1)
+	case d.countTotal > 0:
+		return d.min
+	case d.posInfCount > 0:
+		return math.Inf(+1)
+	}
+	return math.NaN()
+}
+
+// Max returns the largest value added to d, or NaN if d is empty.
+func (d *TDigest) Max() float64 {
+	switch {
+	case d.posInfCount > 0:
+		return math.Inf(+1)
+	case d.countTotal > 0:
+		return d.max
+	case d.negInfCount > 0:
+		return math.Inf(-1)
+	}
+	return math.NaN()
+}
+
+// Compression returns the compression level of d.
+func (d *TDigest) Compression() float64 {
+	return d.compression
+}
+
+// Centroids returns a copy of d's centroids, in increasing order of their
+// means.
+func (d *TDigest) Centroids() []Centroid {
+	centroids := make([]Centroid, len(d.centroids))
+	for i, c := range d.centroids {
+		centroids[i] = Centroid{Mean: c.mean, Count: c.count}
+	}
+	return centroids
 }
 
 // MergeInto(other) will add all of the data within a TDigest into other,
 // combining them into one larger TDigest.
 func (d *TDigest) MergeInto(other *TDigest) {
+	if d.countTotal > 0 {
+		other.updateExtremes(d.min, d.max)
+	}
+	other.nanCount += d.nanCount
+	other.negInfCount += d.negInfCount
+	other.posInfCount += d.posInfCount
+	if other.exact && !d.exact {
+		// other can no longer be exact.
+		other.mergeCentroids(other.compression)
+	}
 	// Add each centroid in d into other. They should be added in
 	// random order.
 	addOrder := rand.Perm(len(d.centroids))
@@ -346,28 +866,202 @@ func (d *TDigest) MergeInto(other *TDigest) {
 		c := d.centroids[idx]
 		// gradually write up the volume written so that the tdigest doesnt overload early
 		added := int64(0)
-		for i := int64(1); i < 10; i++ {
+		for i := int64(1); i < 10 && added < c.count; i++ {
 			toAdd := i * 2
-			if added+i > c.count {
+			if added+toAdd > c.count {
 				toAdd = c.count - added
 			}
 			other.add(c.mean, toAdd)
 			added += toAdd
-			if added >= c.count {
-				break
-			}
 		}
 		if added < c.count {
 			other.add(c.mean, c.count-added)
 		}
-		other.add(c.mean, c.count)
 	}
 }
 
+// Subtract(other) will remove all of the data within other from d. It is
+// meant for finding the difference between two snapshots of a cumulative
+// TDigest, so other should hold a subset of the data in d - for example, an
+// earlier copy of d itself.
+//
+// Removal is approximate. The weight of each centroid in other is taken away
+// from the centroids in d with the nearest means, and any centroids left
+// empty are dropped. Centroid means are not adjusted.
+//
+// The smallest and largest values of d, reported by Min and Max, are not
+// changed unless d is left empty: they become bounds on the remaining data.
+// Counts of NaN and infinite values are subtracted exactly.
+//
+// Subtract returns an error, leaving d unchanged, if other cannot be a subset
+// of d: if it holds more data than d, or values outside the range of d's
+// values, or, when d is in exact mode, any value which d does not hold as
+// many times.
+func (d *TDigest) Subtract(other *TDigest) error {
+	if other.countTotal > d.countTotal {
+		return fmt.Errorf("cannot subtract %d points from a TDigest holding only %d", other.countTotal, d.countTotal)
+	}
+	if other.nanCount > d.nanCount || other.negInfCount > d.negInfCount || other.posInfCount > d.posInfCount {
+		return fmt.Errorf("cannot subtract NaN and infinite counts (%d, %d, %d) from a TDigest holding only (%d, %d, %d)",
+			other.nanCount, other.negInfCount, other.posInfCount, d.nanCount, d.negInfCount, d.posInfCount)
+	}
+	if other.countTotal > 0 && (other.min < d.min || other.max > d.max) {
+		return fmt.Errorf("cannot subtract values in [%v, %v] from a TDigest holding values in [%v, %v]",
+			other.min, other.max, d.min, d.max)
+	}
+
+	counts := make([]int64, len(d.centroids))
+	for i, c := range d.centroids {
+		counts[i] = c.count
+	}
+
+	for _, c := range other.centroids {
+		remaining := c.count
+		// Start from the two centroids of d which straddle c, and work
+		// outwards, always taking weight from whichever is closer.
+		hi := sort.Search(len(d.centroids), func(i int) bool {
+			return d.centroids[i].mean >= c.mean
+		})
+		if d.exact {
+			// d holds every value exactly, so c must match one of them.
+			var have int64
+			if hi < len(counts) && d.centroids[hi].mean == c.mean {
+				have = counts[hi]
+			}
+			if have < c.count {
+				return fmt.Errorf("cannot subtract %d copies of %v from an exact TDigest holding %d", c.count, c.mean, have)
+			}
+			counts[hi] -= c.count
+			continue
+		}
+		lo := hi - 1
+		for remaining > 0 {
+			for lo >= 0 && counts[lo] == 0 {
+				lo--
+			}
+			for hi < len(counts) && counts[hi] == 0 {
+				hi++
+			}
+
+			var idx int
+			switch {
+			case lo < 0 && hi == len(counts):
+				return fmt.Errorf("cannot subtract centroid %v: no weight left to remove it from", c)
+			case lo < 0:
+				idx = hi
+			case hi == len(counts):
+				idx = lo
+			case c.mean-d.centroids[lo].mean <= d.centroids[hi].mean-c.mean:
+				idx = lo
+			default:
+				idx = hi
+			}
+
+			take := remaining
+			if counts[idx] < take {
+				take = counts[idx]
+			}
+			counts[idx] -= take
+			remaining -= take
+		}
+	}
+
+	centroids := make([]centroid, 0, len(d.centroids))
+	for i, c := range d.centroids {
+		if counts[i] > 0 {
+			centroids = append(centroids, centroid{c.mean, counts[i]})
+		}
+	}
+	d.centroids = centroids
+	d.invalidateCumulative()
+	d.countTotal -= other.countTotal
+	if d.countTotal == 0 {
+		d.min, d.max = 0, 0
+	}
+	d.nanCount -= other.nanCount
+	d.negInfCount -= other.negInfCount
+	d.posInfCount -= other.posInfCount
+	if d.exact && !other.exact {
+		// d can no longer be exact.
+		d.mergeCentroids(d.compression)
+	}
+	return nil
+}
+
+// Compress will re-merge adjacent centroids in d so that it uses a new
+// compression level, which should be >= 1.0. This is useful for shrinking a
+// TDigest built with a high compression level before storing or sending it
+// elsewhere. Afterwards, d will hold at most compression+2 centroids.
+//
+// Compressing to a higher level than d already has will not restore any
+// precision; it only allows future additions to be stored more precisely.
+func (d *TDigest) Compress(compression float64) {
+	d.compression = compression
+	d.mergeCentroids(compression)
+}
+
+// mergeCentroids combines runs of adjacent centroids, as long as no merged
+// centroid would span more than one unit of the scale function
+//
+//	k(q) = compression/(2π) * asin(2q-1)
+//
+// which keeps centroids small near the tails and allows them to grow large in
+// the middle of the distribution. The k scale has a total range of
+// compression/2, and each pair of adjacent merged centroids spans at least one
+// unit of it, so at most compression+2 centroids are left.
+//
+// Merging ends exact mode, so d's centroids are no longer exact values.
+func (d *TDigest) mergeCentroids(compression float64) {
+	d.exact = false
+	if len(d.centroids) < 2 || d.countTotal == 0 {
+		return
+	}
+	var (
+		normalizer = compression / (2 * math.Pi)
+		total      = float64(d.countTotal)
+		k          = func(weight int64) float64 {
+			return normalizer * math.Asin(2*float64(weight)/total-1)
+		}
+
+		merged = make([]centroid, 0, len(d.centroids))
+		cur    = d.centroids[0]
+		// weightBefore is the weight of all centroids before cur, and
+		// weightSoFar is the weight up to and including the centroid being
+		// considered for merging into cur.
+		weightBefore int64
+		weightSoFar  = cur.count
+	)
+	for _, c := range d.centroids[1:] {
+		weightSoFar += c.count
+		if k(weightSoFar)-k(weightBefore) <= 1 {
+			cur.count += c.count
+			if cur.count > 0 {
+				cur.mean += float64(c.count) * (c.mean - cur.mean) / float64(cur.count)
+			}
+			continue
+		}
+		merged = append(merged, cur)
+		weightBefore = weightSoFar - c.count
+		cur = c
+	}
+	merged = append(merged, cur)
+	d.centroids = merged
+	d.invalidateCumulative()
+}
+
 // MarshalBinary serializes d as a sequence of bytes, suitable to be
 // deserialized later with UnmarshalBinary.
 func (d *TDigest) MarshalBinary() ([]byte, error) {
-	return marshalBinary(d)
+	return marshalBinary(d, encodingVersion)
+}
+
+// MarshalBinaryVersion serializes d like MarshalBinary, but in the given
+// version of the binary encoding, for readers which only understand an older
+// one. Version 1 does not record the smallest and largest values or exact
+// mode, and cannot hold NaN or infinite values at all, so marshaling a
+// TDigest which counts any of them in version 1 returns an error.
+func (d *TDigest) MarshalBinaryVersion(version int) ([]byte, error) {
+	return marshalBinary(d, int32(version))
 }
 
 // UnmarshalBinary populates d with the parsed contents of p, which should have
@@ -376,16 +1070,30 @@ func (d *TDigest) UnmarshalBinary(p []byte) error {
 	return unmarshalBinary(d, p)
 }
 
+// MarshalJSON serializes d as a JSON object holding its compression level and
+// a list of its centroids. It is less compact than MarshalBinary, but easier
+// to inspect.
+func (d *TDigest) MarshalJSON() ([]byte, error) {
+	return marshalJSON(d)
+}
+
+// UnmarshalJSON populates d with the parsed contents of p, which should have
+// been created with a call to MarshalJSON.
+func (d *TDigest) UnmarshalJSON(p []byte) error {
+	return unmarshalJSON(d, p)
+}
+
 // Render a TDigest's internal state for test logging output purposes.
 func (d *TDigest) debugStr() string {
-	var centroids = "[]*centroids{"
+	var centroids = "[]centroid{"
 
 	for _, c := range d.centroids {
-		centroids += fmt.Sprintf("&centroid{mean: %f, count: %d},", c.mean, c.count)
+		centroids += fmt.Sprintf("{mean: %f, count: %d},", c.mean, c.count)
 	}
 	centroids += "}"
 
-	return fmt.Sprintf("TDigest{compression: %f, countTotal: %d, centroids: %s", d.compression, d.countTotal, centroids)
+	return fmt.Sprintf("TDigest{compression: %f, countTotal: %d, min: %f, max: %f, nanCount: %d, negInfCount: %d, posInfCount: %d, centroids: %s",
+		d.compression, d.countTotal, d.min, d.max, d.nanCount, d.negInfCount, d.posInfCount, centroids)
 
 }
 
@@ -1,28 +1,34 @@
 package tdigest
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"math/rand"
 	"reflect"
+	"sort"
 	"testing"
 )
 
 func TestFindNearest(t *testing.T) {
 	type testcase struct {
-		centroids []*centroid
+		centroids []centroid
 		val       float64
 		want      []int
 	}
 
 	testcases := []testcase{
-		{[]*centroid{{0, 1}, {1, 1}, {2, 1}}, -1, []int{0}},
-		{[]*centroid{{0, 1}, {1, 1}, {2, 1}}, 0, []int{0}},
-		{[]*centroid{{0, 1}, {1, 1}, {2, 1}}, 1, []int{1}},
-		{[]*centroid{{0, 1}, {1, 1}, {2, 1}}, 2, []int{2}},
-		{[]*centroid{{0, 1}, {1, 1}, {2, 1}}, 3, []int{2}},
-		{[]*centroid{{0, 1}, {2, 1}}, 1, []int{0, 1}},
-		{[]*centroid{}, 1, []int{}},
+		{[]centroid{{0, 1}, {1, 1}, {2, 1}}, -1, []int{0}},
+		{[]centroid{{0, 1}, {1, 1}, {2, 1}}, 0, []int{0}},
+		{[]centroid{{0, 1}, {1, 1}, {2, 1}}, 1, []int{1}},
+		{[]centroid{{0, 1}, {1, 1}, {2, 1}}, 2, []int{2}},
+		{[]centroid{{0, 1}, {1, 1}, {2, 1}}, 3, []int{2}},
+		{[]centroid{{0, 1}, {2, 1}}, 1, []int{0, 1}},
+		{[]centroid{}, 1, []int{}},
+		{[]centroid{{0, 1}, {1, 1}, {1, 2}, {2, 1}}, 1, []int{1, 2}},
+		{[]centroid{{0, 1}, {1, 1}, {1, 2}, {3, 1}, {3, 1}}, 2, []int{1, 2, 3, 4}},
+		{[]centroid{{0, 1}, {0, 1}, {5, 1}}, -1, []int{0, 1}},
+		{[]centroid{{0, 1}, {5, 1}, {5, 1}}, 6, []int{1, 2}},
 	}
 
 	for i, tc := range testcases {
@@ -53,7 +59,7 @@ func BenchmarkFindNearest(b *testing.B) {
 }
 
 func TestFindAddTarget(t *testing.T) {
-	testcase := func(in []*centroid, val float64, want int) func(*testing.T) {
+	testcase := func(in []centroid, val float64, want int) func(*testing.T) {
 		return func(t *testing.T) {
 			d := TDigest{centroids: in, compression: 1}
 			for _, c := range in {
@@ -67,38 +73,38 @@ func TestFindAddTarget(t *testing.T) {
 	}
 	t.Run("empty digest", testcase(nil, 1, -1))
 	t.Run("exactly one with room", testcase(
-		[]*centroid{{0.0, 1}, {1.0, 1}, {2.0, 1}},
+		[]centroid{{0.0, 1}, {1.0, 1}, {2.0, 1}},
 		1, 1))
 	t.Run("exactly one without room", testcase(
-		[]*centroid{{0.0, 1}, {1.0, 3}, {2.0, 1}},
+		[]centroid{{0.0, 1}, {1.0, 3}, {2.0, 1}},
 		1, -1))
 	t.Run("multiple candidates", func(t *testing.T) {
 		t.Run("all lesser", func(t *testing.T) {
 			t.Run("with room", testcase(
-				[]*centroid{{0.0, 1}, {1.0, 1}, {1.0, 3}, {2.0, 1}},
+				[]centroid{{0.0, 1}, {1.0, 1}, {1.0, 3}, {2.0, 1}},
 				1.1, 2))
 			t.Run("without room", testcase(
-				[]*centroid{{0.0, 1}, {1.0, 1}, {1.0, 4}, {2.0, 1}},
+				[]centroid{{0.0, 1}, {1.0, 1}, {1.0, 4}, {2.0, 1}},
 				1.1, -1))
 		})
 		t.Run("all greater", func(t *testing.T) {
 			t.Run("with room", testcase(
-				[]*centroid{{0.0, 1}, {1.0, 1}, {1.0, 3}, {2.0, 1}},
+				[]centroid{{0.0, 1}, {1.0, 1}, {1.0, 3}, {2.0, 1}},
 				0.9, 1))
 			t.Run("without room", testcase(
-				[]*centroid{{0.0, 1}, {1.0, 3}, {1.0, 4}, {2.0, 1}},
+				[]centroid{{0.0, 1}, {1.0, 3}, {1.0, 4}, {2.0, 1}},
 				0.9, -1))
 		})
 		t.Run("all equal", func(t *testing.T) {
 			t.Run("with room in none", testcase(
-				[]*centroid{{0.0, 1}, {1.0, 3}, {1.0, 3}, {2.0, 1}},
+				[]centroid{{0.0, 1}, {1.0, 3}, {1.0, 3}, {2.0, 1}},
 				1.0, -1))
 			t.Run("with room in one", testcase(
-				[]*centroid{{0.0, 1}, {1.0, 2}, {1.0, 3}, {2.0, 1}},
+				[]centroid{{0.0, 1}, {1.0, 2}, {1.0, 3}, {2.0, 1}},
 				1.0, 1))
 			t.Run("with room in multiple", func(t *testing.T) {
 				d := TDigest{
-					centroids:   []*centroid{{0.0, 1}, {1.0, 1}, {1.0, 2}, {2.0, 1}},
+					centroids:   []centroid{{0.0, 1}, {1.0, 1}, {1.0, 2}, {2.0, 1}},
 					compression: 1,
 				}
 				for _, c := range d.centroids {
@@ -112,17 +118,17 @@ func TestFindAddTarget(t *testing.T) {
 		})
 		t.Run("both greater and lesser", func(t *testing.T) {
 			t.Run("with room below", testcase(
-				[]*centroid{{0.0, 1}, {0.8, 1}, {0.8, 1}, {1.0, 6}, {1.0, 1}, {2.0, 1}},
+				[]centroid{{0.0, 1}, {0.8, 1}, {0.8, 1}, {1.0, 6}, {1.0, 1}, {2.0, 1}},
 				0.9, 2))
 			t.Run("with room above", testcase(
-				[]*centroid{{0.0, 1}, {0.8, 1}, {0.8, 6}, {1.0, 1}, {1.0, 1}, {2.0, 1}},
+				[]centroid{{0.0, 1}, {0.8, 1}, {0.8, 6}, {1.0, 1}, {1.0, 1}, {2.0, 1}},
 				0.9, 3))
 			t.Run("with no room", testcase(
-				[]*centroid{{0.0, 1}, {0.8, 1}, {0.8, 6}, {1.0, 6}, {1.0, 1}, {2.0, 1}},
+				[]centroid{{0.0, 1}, {0.8, 1}, {0.8, 6}, {1.0, 6}, {1.0, 1}, {2.0, 1}},
 				0.9, -1))
 			t.Run("with room above and below", func(t *testing.T) {
 				d := TDigest{
-					centroids: []*centroid{
+					centroids: []centroid{
 						{0.0, 1}, {0.8, 1}, {0.8, 1},
 						{1.0, 1}, {1.0, 1}, {2.0, 1}},
 					compression: 1,
@@ -190,34 +196,34 @@ func TestQuantileOrder(t *testing.T) {
 	d := &TDigest{
 		countTotal:  14182,
 		compression: 100,
-		centroids: []*centroid{
-			&centroid{0.000000, 1},
-			&centroid{0.000000, 564},
-			&centroid{0.000000, 1140},
-			&centroid{0.000000, 1713},
-			&centroid{0.000000, 2380},
-			&centroid{0.000000, 2688},
-			&centroid{0.000000, 1262},
-			&centroid{2.005758, 1563},
-			&centroid{30.499251, 1336},
-			&centroid{381.533509, 761},
-			&centroid{529.600000, 5},
-			&centroid{1065.294118, 17},
-			&centroid{2266.444444, 36},
-			&centroid{4268.809783, 368},
-			&centroid{14964.148148, 27},
-			&centroid{41024.579618, 157},
-			&centroid{124311.192308, 52},
-			&centroid{219674.636364, 22},
-			&centroid{310172.775000, 40},
-			&centroid{412388.642857, 14},
-			&centroid{582867.000000, 16},
-			&centroid{701434.777778, 9},
-			&centroid{869363.800000, 5},
-			&centroid{968264.000000, 1},
-			&centroid{987100.666667, 3},
-			&centroid{1029895.000000, 1},
-			&centroid{1034640.000000, 1},
+		centroids: []centroid{
+			{0.000000, 1},
+			{0.000000, 564},
+			{0.000000, 1140},
+			{0.000000, 1713},
+			{0.000000, 2380},
+			{0.000000, 2688},
+			{0.000000, 1262},
+			{2.005758, 1563},
+			{30.499251, 1336},
+			{381.533509, 761},
+			{529.600000, 5},
+			{1065.294118, 17},
+			{2266.444444, 36},
+			{4268.809783, 368},
+			{14964.148148, 27},
+			{41024.579618, 157},
+			{124311.192308, 52},
+			{219674.636364, 22},
+			{310172.775000, 40},
+			{412388.642857, 14},
+			{582867.000000, 16},
+			{701434.777778, 9},
+			{869363.800000, 5},
+			{968264.000000, 1},
+			{987100.666667, 3},
+			{1029895.000000, 1},
+			{1034640.000000, 1},
 		},
 	}
 	d.Add(1.0, 1)
@@ -260,18 +266,18 @@ func TestAddValue(t *testing.T) {
 	type testcase struct {
 		value  float64
 		weight int
-		want   []*centroid
+		want   []centroid
 	}
 
 	testcases := []testcase{
-		{1.0, 1, []*centroid{{1, 1}}},
-		{0.0, 1, []*centroid{{0, 1}, {1, 1}}},
-		{2.0, 1, []*centroid{{0, 1}, {1, 1}, {2, 1}}},
-		{3.0, 1, []*centroid{{0, 1}, {1, 1}, {2.5, 2}}},
-		{4.0, 1, []*centroid{{0, 1}, {1, 1}, {2.5, 2}, {4, 1}}},
-		{math.NaN(), 1, []*centroid{{0, 1}, {1, 1}, {2.5, 2}, {4, 1}}},
-		{math.Inf(-1), 1, []*centroid{{0, 1}, {1, 1}, {2.5, 2}, {4, 1}}},
-		{math.Inf(+1), 1, []*centroid{{0, 1}, {1, 1}, {2.5, 2}, {4, 1}}},
+		{1.0, 1, []centroid{{1, 1}}},
+		{0.0, 1, []centroid{{0, 1}, {1, 1}}},
+		{2.0, 1, []centroid{{0, 1}, {1, 1}, {2, 1}}},
+		{3.0, 1, []centroid{{0, 1}, {1, 1}, {2.5, 2}}},
+		{4.0, 1, []centroid{{0, 1}, {1, 1}, {2.5, 2}, {4, 1}}},
+		{math.NaN(), 1, []centroid{{0, 1}, {1, 1}, {2.5, 2}, {4, 1}}},
+		{math.Inf(-1), 1, []centroid{{0, 1}, {1, 1}, {2.5, 2}, {4, 1}}},
+		{math.Inf(+1), 1, []centroid{{0, 1}, {1, 1}, {2.5, 2}, {4, 1}}},
 	}
 
 	d := NewWithCompression(1)
@@ -283,10 +289,30 @@ func TestAddValue(t *testing.T) {
 	}
 }
 
+func TestAddWeightCount(t *testing.T) {
+	// Heavy weights overflow the nearest centroid and are split across
+	// several; each unit of weight should still be counted once.
+	d := NewWithCompression(1)
+	var want int64
+	for i := 0; i < 100; i++ {
+		w := i%7*5 + 1
+		d.Add(float64(i%10), w)
+		want += int64(w)
+	}
+	var sum int64
+	for _, c := range d.centroids {
+		sum += c.count
+	}
+	if d.countTotal != want || sum != want {
+		t.Errorf("wrong total weight, have countTotal=%d centroids=%d, want=%d", d.countTotal, sum, want)
+	}
+}
+
 func TestQuantileValue(t *testing.T) {
 	d := NewWithCompression(1)
 	d.countTotal = 8
-	d.centroids = []*centroid{{0.5, 3}, {1, 1}, {2, 2}, {3, 1}, {8, 1}}
+	d.centroids = []centroid{{0.5, 3}, {1, 1}, {2, 2}, {3, 1}, {8, 1}}
+	d.min, d.max = 0, 8
 
 	type testcase struct {
 		q    float64
@@ -295,17 +321,20 @@ func TestQuantileValue(t *testing.T) {
 
 	// correct values, determined by hand with pen and paper for this set of centroids
 	testcases := []testcase{
-		{0.0, 5.0 / 40.0},
-		{0.1, 13.0 / 40.0},
-		{0.2, 21.0 / 40.0},
-		{0.3, 29.0 / 40.0},
+		{0.0, 0},
+		{0.05, 0},
+		{0.1, 3.0 / 20.0},
+		{0.15, 7.0 / 20.0},
+		{0.25, 5.0 / 8.0},
 		{0.4, 37.0 / 40.0},
 		{0.5, 20.0 / 15.0},
+		{0.55, 24.0 / 15.0},
 		{0.6, 28.0 / 15.0},
 		{0.7, 36.0 / 15.0},
 		{0.8, 44.0 / 15.0},
+		{0.85, 9.0 / 2.0},
 		{0.9, 13.0 / 2.0},
-		{1.0, 21.0 / 2.0},
+		{1.0, 8},
 	}
 
 	var epsilon = 1e-8
@@ -319,6 +348,72 @@ func TestQuantileValue(t *testing.T) {
 	}
 }
 
+func TestQuantileSingletons(t *testing.T) {
+	// a skewed set of centroids, with outliers in singletons in the tail
+	outliers := []float64{50, 900}
+	d := NewWithCompression(1)
+	d.countTotal = 92
+	d.centroids = []centroid{{1, 40}, {2, 30}, {4, 20}, {outliers[0], 1}, {outliers[1], 1}}
+	d.min, d.max = 0.5, outliers[1]
+
+	// Each outlier is a single sample, which should be returned exactly at
+	// the middle of the unit of rank it covers, and not extrapolated past:
+	// the largest one is also the maximum.
+	total := float64(d.Count())
+	for i, v := range outliers {
+		rank := total - float64(len(outliers)-i) + 0.5
+		if have := d.Quantile(rank / total); math.Abs(have-v) > 1e-9*v {
+			t.Errorf("TDigest.Quantile(%v) wrong, have=%v, want=%v", rank/total, have, v)
+		}
+		if have, want := d.CDF(v), rank/total; math.Abs(have-want) > 1e-12 {
+			t.Errorf("TDigest.CDF(%v) wrong, have=%v, want=%v", v, have, want)
+		}
+	}
+	if have := d.Quantile(1); have != outliers[1] {
+		t.Errorf("TDigest.Quantile(1) wrong, have=%v, want=%v", have, outliers[1])
+	}
+	if have := d.Quantile(0); have != d.Min() {
+		t.Errorf("TDigest.Quantile(0) wrong, have=%v, want=%v", have, d.Min())
+	}
+}
+
+func TestQuantileSmallMedians(t *testing.T) {
+	// With every sample in its own centroid, the median of an even number
+	// of them is halfway between the middle two.
+	for n, want := range map[int]float64{1: 1, 2: 1.5, 3: 2, 4: 2.5, 5: 3, 10: 5.5} {
+		d := New()
+		for i := 1; i <= n; i++ {
+			d.Add(float64(i), 1)
+		}
+		if have := d.Quantile(0.5); have != want {
+			t.Errorf("median of 1 to %d wrong, have=%v, want=%v", n, have, want)
+		}
+		if have := d.CDF(want); have != 0.5 {
+			t.Errorf("CDF at the median of 1 to %d wrong, have=%v, want=%v", n, have, 0.5)
+		}
+	}
+}
+
+func TestQuantileSingletonsMatchR5(t *testing.T) {
+	rand.Seed(rngSeed)
+	for i := 0; i < 100; i++ {
+		vals := make([]float64, rand.Intn(30)+1)
+		exact := New(WithExactThreshold(len(vals)), WithQuantileMethod(R5))
+		for j := range vals {
+			vals[j] = rand.NormFloat64()
+			exact.Add(vals[j], 1)
+		}
+		sort.Float64s(vals)
+		d := tdFromMeans(vals)
+		for k := 0; k <= 100; k++ {
+			q := float64(k) / 100
+			if have, want := d.Quantile(q), exact.Quantile(q); math.Abs(have-want) > 1e-12 {
+				t.Fatalf("Quantile(%v) of %d singletons differs from R5, have=%v, want=%v", q, len(vals), have, want)
+			}
+		}
+	}
+}
+
 func BenchmarkFindAddTarget(b *testing.B) {
 	n := 500
 	d := simpleTDigest(n)
@@ -340,27 +435,35 @@ func simpleTDigest(n int) *TDigest {
 	return d
 }
 
+// equalTDigests reports whether a and b hold the same configuration and
+// data, ignoring anything they have cached.
+func equalTDigests(a, b *TDigest) bool {
+	return reflect.DeepEqual(a.Clone(), b.Clone())
+}
+
 func tdFromMeans(means []float64) *TDigest {
-	centroids := make([]*centroid, len(means))
+	centroids := make([]centroid, len(means))
 	for i, m := range means {
-		centroids[i] = &centroid{m, 1}
+		centroids[i] = centroid{m, 1}
 	}
 	d := NewWithCompression(1.0)
 	d.centroids = centroids
 	d.countTotal = int64(len(centroids))
+	d.setExtremesFromCentroids()
 	return d
 }
 
 func tdFromWeights(weights []int64) *TDigest {
-	centroids := make([]*centroid, len(weights))
+	centroids := make([]centroid, len(weights))
 	countTotal := int64(0)
 	for i, w := range weights {
-		centroids[i] = &centroid{float64(i), w}
+		centroids[i] = centroid{float64(i), w}
 		countTotal += w
 	}
 	d := NewWithCompression(1.0)
 	d.centroids = centroids
 	d.countTotal = countTotal
+	d.setExtremesFromCentroids()
 	return d
 }
 
@@ -431,6 +534,10 @@ func TestMerge(t *testing.T) {
 	td := New()
 	td1.MergeInto(td)
 	td2.MergeInto(td)
+	if td.countTotal != int64(n) {
+		t.Errorf("merged TDigest has wrong count, have=%d, want=%d", td.countTotal, n)
+	}
+	verifyCentroidOrder(t, td)
 	t.Logf("10th: %.5f\n", td1.Quantile(0.1))
 	t.Logf("50th: %.5f\n", td1.Quantile(0.5))
 	t.Logf("90th: %.5f\n", td1.Quantile(0.9))
@@ -452,3 +559,714 @@ func TestMerge(t *testing.T) {
 	t.Logf("99.9th: %.5f\n", td.Quantile(0.999))
 	t.Logf("99.99th: %.5f\n", td.Quantile(0.9999))
 }
+
+func TestSubtract(t *testing.T) {
+	rand.Seed(3)
+	d := New()
+	for i := 0; i < 10000; i++ {
+		d.Add(rand.Float64()*100, 1)
+	}
+
+	// Snapshot the digest, then keep adding data from a different range.
+	b, err := d.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary err: %v", err)
+	}
+	prev := new(TDigest)
+	if err := prev.UnmarshalBinary(b); err != nil {
+		t.Fatalf("UnmarshalBinary err: %v", err)
+	}
+	for i := 0; i < 10000; i++ {
+		d.Add(100+rand.Float64()*100, 1)
+	}
+
+	if err := d.Subtract(prev); err != nil {
+		t.Fatalf("Subtract err: %v", err)
+	}
+	if d.countTotal != 10000 {
+		t.Errorf("TDigest.Subtract wrong count, have=%d, want=%d", d.countTotal, 10000)
+	}
+	verifyCentroidOrder(t, d)
+
+	type testcase struct {
+		q    float64
+		want float64
+	}
+	testcases := []testcase{
+		{0.1, 110},
+		{0.5, 150},
+		{0.9, 190},
+	}
+	for i, tc := range testcases {
+		have := d.Quantile(tc.q)
+		if math.Abs(have-tc.want) > 2 {
+			t.Errorf("TDigest.Subtract wrong quantile test=%d, have=%v, want=%v", i, have, tc.want)
+		}
+	}
+}
+
+func TestSubtractErrors(t *testing.T) {
+	d := simpleTDigest(10)
+	before := d.debugStr()
+	err := d.Subtract(simpleTDigest(11))
+	if err == nil {
+		t.Fatal("expected error subtracting a larger TDigest, got nil")
+	}
+	if after := d.debugStr(); after != before {
+		t.Errorf("failed Subtract modified the TDigest, have=%s, want=%s", after, before)
+	}
+
+	if err := d.Subtract(simpleTDigest(10)); err != nil {
+		t.Fatalf("Subtract err: %v", err)
+	}
+	if d.countTotal != 0 || len(d.centroids) != 0 {
+		t.Errorf("subtracting an identical TDigest should leave it empty: %s", d.debugStr())
+	}
+
+	// other holds fewer values than d, but they can't have been added to
+	// it.
+	d = simpleTDigest(100)
+	before = d.debugStr()
+	outside := New()
+	for i := 1000; i < 1050; i++ {
+		outside.Add(float64(i), 1)
+	}
+	err = d.Subtract(outside)
+	want := "cannot subtract values in [1000, 1049] from a TDigest holding values in [0, 99]"
+	if err == nil || err.Error() != want {
+		t.Errorf("wrong error subtracting values out of range, have=%v, want=%q", err, want)
+	}
+	if after := d.debugStr(); after != before {
+		t.Errorf("failed Subtract modified the TDigest, have=%s, want=%s", after, before)
+	}
+
+	// In exact mode, every value subtracted must have been added.
+	exact, missing := New(WithExactThreshold(100)), New(WithExactThreshold(100))
+	for i := 0; i < 10; i++ {
+		exact.Add(float64(i), 1)
+	}
+	missing.Add(2, 1)
+	missing.Add(2.5, 1)
+	missing.Add(3, 1)
+	err = exact.Subtract(missing)
+	want = "cannot subtract 1 copies of 2.5 from an exact TDigest holding 0"
+	if err == nil || err.Error() != want {
+		t.Errorf("wrong error subtracting a value not held exactly, have=%v, want=%q", err, want)
+	}
+	if exact.Count() != 10 {
+		t.Errorf("failed Subtract modified the TDigest: %s", exact.debugStr())
+	}
+	present := New(WithExactThreshold(100))
+	present.Add(2, 1)
+	present.Add(3, 1)
+	if err := exact.Subtract(present); err != nil {
+		t.Fatalf("Subtract err: %v", err)
+	}
+	if exact.Count() != 8 || !exact.Exact() {
+		t.Errorf("wrong result subtracting exact values: %s", exact.debugStr())
+	}
+}
+
+func TestCompress(t *testing.T) {
+	rand.Seed(4)
+	d := NewWithCompression(1000)
+	for i := 0; i < 100000; i++ {
+		d.Add(rand.NormFloat64(), 1)
+	}
+	before := make([]float64, 0)
+	qs := []float64{0.001, 0.01, 0.1, 0.5, 0.9, 0.99, 0.999}
+	for _, q := range qs {
+		before = append(before, d.Quantile(q))
+	}
+
+	d.Compress(50)
+	if d.compression != 50 {
+		t.Errorf("TDigest.Compress did not set compression, have=%v, want=%v", d.compression, 50)
+	}
+	if len(d.centroids) > 52 {
+		t.Errorf("TDigest.Compress left too many centroids, have=%d, want<=%d", len(d.centroids), 52)
+	}
+	var total int64
+	for _, c := range d.centroids {
+		total += c.count
+	}
+	if total != 100000 || d.countTotal != 100000 {
+		t.Errorf("TDigest.Compress changed count, have=%d (centroids sum to %d), want=%d", d.countTotal, total, 100000)
+	}
+	verifyCentroidOrder(t, d)
+
+	for i, q := range qs {
+		if have := d.Quantile(q); math.Abs(have-before[i]) > 0.2 {
+			t.Errorf("TDigest.Compress changed quantile q=%v too much, have=%v, want=%v", q, have, before[i])
+		}
+	}
+}
+
+func TestMaxCentroids(t *testing.T) {
+	const limit = 50
+	d := New(WithMaxCentroids(limit))
+	// Ordered input is the worst case for the number of centroids.
+	for i := 0; i < 100000; i++ {
+		d.Add(float64(i), 1)
+		if len(d.centroids) > limit {
+			t.Fatalf("TDigest has too many centroids after %d additions, have=%d, want<=%d", i+1, len(d.centroids), limit)
+		}
+	}
+	if d.countTotal != 100000 {
+		t.Errorf("TDigest has wrong count, have=%d, want=%d", d.countTotal, 100000)
+	}
+	verifyCentroidOrder(t, d)
+	if have := d.Quantile(0.5); math.Abs(have-50000) > 2500 {
+		t.Errorf("TDigest.Quantile(0.5) wrong, have=%v, want=%v", have, 50000)
+	}
+
+	t.Run("merge", func(t *testing.T) {
+		other := simpleTDigest(1000)
+		merged := New(WithMaxCentroids(limit))
+		other.MergeInto(merged)
+		if len(merged.centroids) > limit {
+			t.Errorf("merged TDigest has too many centroids, have=%d, want<=%d", len(merged.centroids), limit)
+		}
+	})
+}
+
+func TestSizeBytes(t *testing.T) {
+	small, large := simpleTDigest(10), simpleTDigest(1000)
+	if small.SizeBytes() >= large.SizeBytes() {
+		t.Errorf("TDigest.SizeBytes should grow with centroids, have %d for %d centroids and %d for %d centroids",
+			small.SizeBytes(), len(small.centroids), large.SizeBytes(), len(large.centroids))
+	}
+	limited := New(WithMaxCentroids(10))
+	for i := 0; i < 1000; i++ {
+		limited.Add(float64(i), 1)
+	}
+	if limited.SizeBytes() >= large.SizeBytes() {
+		t.Errorf("limited TDigest.SizeBytes should be smaller, have=%d, unlimited=%d", limited.SizeBytes(), large.SizeBytes())
+	}
+
+	// Queries cache a count for each centroid, plus one. Clones start
+	// without the cache.
+	c := large.Clone()
+	before := c.SizeBytes()
+	c.Quantile(0.5)
+	want := before + (len(c.centroids)+1)*8
+	if have := c.SizeBytes(); have < want {
+		t.Errorf("TDigest.SizeBytes should count cached cumulative counts, have=%d after a query, want>=%d", have, want)
+	}
+}
+
+func TestCDFValue(t *testing.T) {
+	d := NewWithCompression(1)
+	d.countTotal = 8
+	d.centroids = []centroid{{0.5, 3}, {1, 1}, {2, 2}, {3, 1}, {8, 1}}
+	d.min, d.max = 0, 8
+
+	type testcase struct {
+		x    float64
+		want float64
+	}
+
+	// the inverse of the values in TestQuantileValue, where Quantile is
+	// not flat
+	testcases := []testcase{
+		{-100, 0.0},
+		{0, 0.5 / 8},
+		{3.0 / 20.0, 0.1},
+		{7.0 / 20.0, 0.15},
+		{0.5, 1.5 / 8},
+		{5.0 / 8.0, 0.25},
+		{1, 3.5 / 8},
+		{20.0 / 15.0, 0.5},
+		{24.0 / 15.0, 0.55},
+		{28.0 / 15.0, 0.6},
+		{36.0 / 15.0, 0.7},
+		{3, 6.5 / 8},
+		{9.0 / 2.0, 0.85},
+		{13.0 / 2.0, 0.9},
+		{8, 7.5 / 8},
+		{100, 1.0},
+	}
+
+	var epsilon = 1e-8
+
+	for i, tc := range testcases {
+		have := d.CDF(tc.x)
+		if math.Abs(have-tc.want) > epsilon {
+			t.Errorf("TDigest.CDF wrong step=%d, have=%v, want=%v",
+				i, have, tc.want)
+		}
+	}
+}
+
+func TestCDFEdgeCases(t *testing.T) {
+	if have := New().CDF(1); !math.IsNaN(have) {
+		t.Errorf("TDigest.CDF of empty digest should be NaN, have=%v", have)
+	}
+
+	d := New()
+	d.Add(1, 1)
+	for _, tc := range []struct{ x, want float64 }{{0, 0}, {1, 0.5}, {2, 1}} {
+		if have := d.CDF(tc.x); have != tc.want {
+			t.Errorf("TDigest.CDF(%v) wrong with one centroid, have=%v, want=%v", tc.x, have, tc.want)
+		}
+	}
+
+	// CDF should never decrease, even across centroids with equal means, or
+	// with singletons at the ends that don't hold the smallest and largest
+	// values, as after merging digests.
+	d = tdFromMeans([]float64{0, 1, 1, 1, 2})
+	d2 := NewWithCompression(1)
+	d2.countTotal = 6
+	d2.centroids = []centroid{{2, 1}, {5, 4}, {9, 1}}
+	d2.min, d2.max = 0, 11
+	for _, d := range []*TDigest{d, d2} {
+		prev := 0.0
+		for x := -1.0; x <= 12; x += 0.125 {
+			have := d.CDF(x)
+			if have < prev {
+				t.Errorf("TDigest.CDF decreased at x=%v, have=%v, prev=%v: %s", x, have, prev, d.debugStr())
+			}
+			prev = have
+		}
+	}
+
+	// NaN is not less than or equal to anything, but neither is it
+	// greater, so its CDF is undefined.
+	exact := New(WithExactThreshold(100))
+	linear := New(WithInterpolation(Linear))
+	for _, d := range []*TDigest{exact, linear} {
+		for i := 0; i < 10; i++ {
+			d.Add(float64(i), 1)
+		}
+	}
+	for name, d := range map[string]*TDigest{
+		"midpoint": simpleTDigest(100),
+		"exact":    exact,
+		"linear":   linear,
+	} {
+		if have := d.CDF(math.NaN()); !math.IsNaN(have) {
+			t.Errorf("%s TDigest.CDF(NaN) should be NaN, have=%v", name, have)
+		}
+	}
+}
+
+func TestCentroids(t *testing.T) {
+	d := tdFromWeights([]int64{1, 2, 3})
+	want := []Centroid{{0, 1}, {1, 2}, {2, 3}}
+	if have := d.Centroids(); !reflect.DeepEqual(have, want) {
+		t.Errorf("TDigest.Centroids wrong, have=%v, want=%v", have, want)
+	}
+	if have := d.Count(); have != 6 {
+		t.Errorf("TDigest.Count wrong, have=%v, want=%v", have, 6)
+	}
+}
+
+func TestMinMax(t *testing.T) {
+	d := New()
+	if !math.IsNaN(d.Min()) || !math.IsNaN(d.Max()) {
+		t.Errorf("empty TDigest should have NaN extremes, have min=%v max=%v", d.Min(), d.Max())
+	}
+	for _, v := range []float64{3, -1, 7, 2} {
+		d.Add(v, 1)
+	}
+	if d.Min() != -1 || d.Max() != 7 {
+		t.Errorf("wrong extremes, have min=%v max=%v, want min=-1 max=7", d.Min(), d.Max())
+	}
+
+	other := New()
+	other.Add(10, 1)
+	d.MergeInto(other)
+	if other.Min() != -1 || other.Max() != 10 {
+		t.Errorf("wrong extremes after merge, have min=%v max=%v, want min=-1 max=10", other.Min(), other.Max())
+	}
+}
+
+func TestReset(t *testing.T) {
+	d := New(WithCompression(10), WithMaxCentroids(20))
+	for i := 0; i < 1000; i++ {
+		d.Add(rand.Float64(), 1)
+	}
+	d.Reset()
+	if !reflect.DeepEqual(d.Centroids(), []Centroid{}) {
+		t.Errorf("TDigest.Reset left centroids: %v", d.Centroids())
+	}
+	if d.Count() != 0 || !math.IsNaN(d.Min()) || !math.IsNaN(d.Max()) {
+		t.Errorf("TDigest.Reset left data, count=%d min=%v max=%v", d.Count(), d.Min(), d.Max())
+	}
+	if d.Compression() != 10 || d.maxCentroids != 20 {
+		t.Errorf("TDigest.Reset changed configuration, compression=%v maxCentroids=%d", d.Compression(), d.maxCentroids)
+	}
+
+	d.Add(5, 1)
+	if d.Quantile(0.5) != 5 || d.Min() != 5 || d.Max() != 5 {
+		t.Errorf("reset TDigest has wrong data, median=%v min=%v max=%v", d.Quantile(0.5), d.Min(), d.Max())
+	}
+}
+
+func TestClone(t *testing.T) {
+	d := New(WithCompression(20), WithMaxCentroids(50))
+	for i := 0; i < 1000; i++ {
+		d.Add(rand.NormFloat64(), 1)
+	}
+	before := d.debugStr()
+
+	c := d.Clone()
+	if !equalTDigests(c, d) {
+		t.Fatalf("TDigest.Clone differs from original\nhave=%s\nwant=%s", c.debugStr(), d.debugStr())
+	}
+
+	for i := 0; i < 1000; i++ {
+		c.Add(rand.NormFloat64()+10, 1)
+	}
+	if after := d.debugStr(); after != before {
+		t.Errorf("changing clone changed original\nbefore=%s\nafter=%s", before, after)
+	}
+	if c.Count() != 2000 || c.maxCentroids != 50 {
+		t.Errorf("clone has wrong state, count=%d maxCentroids=%d", c.Count(), c.maxCentroids)
+	}
+}
+
+// linearQuantile is TDigest.Quantile with the centroids to interpolate
+// between found by a linear scan, rather than a search of the cached
+// cumulative counts. It is kept to check that the cache is never stale, and
+// to benchmark the search against. It interpolates just as Quantile does, so
+// it is no check on the interpolation itself; see knotQuantile for that.
+func linearQuantile(d *TDigest, q float64) float64 {
+	return math.Max(d.min, math.Min(linearUnclampedQuantile(d, q), d.max))
+}
+
+func linearUnclampedQuantile(d *TDigest, q float64) float64 {
+	var n = len(d.centroids)
+	if n == 0 {
+		return math.NaN()
+	}
+
+	if q < 0 {
+		q = 0
+	} else if q > 1 {
+		q = 1
+	}
+
+	var (
+		total = float64(d.countTotal)
+		index = q * total
+		first = d.centroids[0]
+		last  = d.centroids[n-1]
+	)
+	if index < 0.5 {
+		return d.min
+	}
+	if first.count > 1 && index < float64(first.count)/2 {
+		v := d.min + (index-0.5)/(float64(first.count)/2-0.5)*(first.mean-d.min)
+		return math.Min(v, first.mean)
+	}
+	if index > total-0.5 {
+		return d.max
+	}
+	if last.count > 1 && total-index <= float64(last.count)/2 {
+		v := d.max - (total-index-0.5)/(float64(last.count)/2-0.5)*(d.max-last.mean)
+		return math.Max(v, last.mean)
+	}
+
+	weightSoFar := float64(first.count) / 2
+	for i := 0; i < n-1; i++ {
+		c0, c1 := d.centroids[i], d.centroids[i+1]
+		dw := float64(c0.count)/2 + float64(c1.count)/2
+		if weightSoFar+dw <= index {
+			weightSoFar += dw
+			continue
+		}
+		v := c0.mean + (c1.mean-c0.mean)*((index-weightSoFar)/dw)
+		return math.Max(c0.mean, math.Min(v, c1.mean))
+	}
+	return last.mean
+}
+
+// knotQuantile is an independent statement of the interpolation done by
+// TDigest.Quantile, for checking it. The quantile function is piecewise
+// linear in rank, through a knot at the middle of each centroid. A centroid
+// with a weight of 1 is a single sample, so its knot is at the middle of the
+// unit of rank which the sample occupies. The smallest and largest values are
+// single samples too, in the first and last units of rank.
+func knotQuantile(d *TDigest, q float64) float64 {
+	if len(d.centroids) == 0 {
+		return math.NaN()
+	}
+	type knot struct{ rank, value float64 }
+	var (
+		total = float64(d.countTotal)
+		rank  = math.Max(0, math.Min(1, q)) * total
+		knots = []knot{{0.5, d.min}}
+		start float64
+	)
+	for _, c := range d.centroids {
+		w := float64(c.count)
+		knots = append(knots, knot{start + w/2, c.mean})
+		start += w
+	}
+	knots = append(knots, knot{total - 0.5, d.max})
+	// Sort stably, so that at equal ranks the smallest value comes before
+	// the knots of the first centroid, and the largest after those of the
+	// last.
+	sort.SliceStable(knots, func(i, j int) bool { return knots[i].rank < knots[j].rank })
+
+	if rank < 0.5 {
+		return d.min
+	}
+	if rank > total-0.5 {
+		return d.max
+	}
+	// Interpolate between the last knot at or below rank and the first
+	// above it.
+	j := sort.Search(len(knots), func(j int) bool { return knots[j].rank > rank })
+	if j == len(knots) {
+		return knots[j-1].value
+	}
+	k0, k1 := knots[j-1], knots[j]
+	return k0.value + (k1.value-k0.value)*(rank-k0.rank)/(k1.rank-k0.rank)
+}
+
+func TestQuantileMatchesKnots(t *testing.T) {
+	rand.Seed(rngSeed)
+	for i := 0; i < 200; i++ {
+		// Random centroids, many of them singletons, with the extremes
+		// sometimes beyond the outermost means.
+		n := rand.Intn(20) + 1
+		d := NewWithCompression(100)
+		mean := rand.NormFloat64()
+		for j := 0; j < n; j++ {
+			var count int64 = 1
+			if rand.Intn(2) == 0 {
+				count = rand.Int63n(20) + 2
+			}
+			d.centroids = append(d.centroids, centroid{mean, count})
+			d.countTotal += count
+			mean += rand.ExpFloat64()
+		}
+		d.min = d.centroids[0].mean - float64(rand.Intn(2))*rand.ExpFloat64()
+		d.max = d.centroids[n-1].mean + float64(rand.Intn(2))*rand.ExpFloat64()
+
+		for k := 0; k < 100; k++ {
+			q := rand.Float64()
+			have, want := d.Quantile(q), knotQuantile(d, q)
+			if math.Abs(have-want) > 1e-9*(d.max-d.min) {
+				t.Fatalf("Quantile(%v) differs from knots, have=%v, want=%v: %s", q, have, want, d.debugStr())
+			}
+		}
+	}
+}
+
+func TestQuantileMatchesLinear(t *testing.T) {
+	rand.Seed(rngSeed)
+	d := New()
+	other := New()
+	for i := 0; i < 10000; i++ {
+		other.Add(rand.ExpFloat64(), 1)
+	}
+
+	check := func(stage string) {
+		t.Helper()
+		for _, q := range []float64{-1, 0, 0.0001, 0.01, 0.25, 0.5, 0.75, 0.99, 0.9999, 1, 2} {
+			have, want := d.Quantile(q), linearQuantile(d, q)
+			if have != want && !(math.IsNaN(have) && math.IsNaN(want)) {
+				t.Errorf("%s: Quantile(%v) differs from reference, have=%v, want=%v", stage, q, have, want)
+			}
+		}
+	}
+
+	// Interleave queries with every kind of change to d, to make sure
+	// that cached data is never stale.
+	check("empty")
+	for i := 0; i < 10000; i++ {
+		d.Add(rand.NormFloat64(), rand.Intn(5)+1)
+		if i%1000 == 0 {
+			check("add")
+		}
+	}
+	check("add")
+	other.MergeInto(d)
+	check("merge")
+	if err := d.Subtract(other); err != nil {
+		t.Fatalf("Subtract err: %v", err)
+	}
+	check("subtract")
+	d.Compress(20)
+	check("compress")
+	p, _ := other.MarshalBinary()
+	if err := d.UnmarshalBinary(p); err != nil {
+		t.Fatalf("UnmarshalBinary err: %v", err)
+	}
+	check("unmarshal")
+	d.Reset()
+	check("reset")
+}
+
+// nonFiniteTDigest returns a TDigest holding finite, NaN and infinite values.
+// It is not created with WithNonFinite, since that option is not serialized.
+func nonFiniteTDigest() *TDigest {
+	d := simpleTDigest(100)
+	d.nanCount, d.negInfCount, d.posInfCount = 3, 10, 20
+	return d
+}
+
+func TestNonFinite(t *testing.T) {
+	d := New(WithNonFinite())
+	for i := 0; i < 100; i++ {
+		d.Add(float64(i), 1)
+	}
+	d.Add(math.NaN(), 3)
+	d.Add(math.Inf(-1), 10)
+	d.Add(math.Inf(+1), 20)
+	if have := d.Count(); have != 130 {
+		t.Errorf("TDigest.Count wrong, have=%d, want=%d", have, 130)
+	}
+	if have := d.NaNCount(); have != 3 {
+		t.Errorf("TDigest.NaNCount wrong, have=%d, want=%d", have, 3)
+	}
+	if neg, pos := d.InfCounts(); neg != 10 || pos != 20 {
+		t.Errorf("TDigest.InfCounts wrong, have=%d,%d, want=%d,%d", neg, pos, 10, 20)
+	}
+	if !math.IsInf(d.Min(), -1) || !math.IsInf(d.Max(), +1) {
+		t.Errorf("wrong extremes, have min=%v max=%v", d.Min(), d.Max())
+	}
+
+	type quantileTest struct {
+		q    float64
+		want float64
+	}
+	for _, tc := range []quantileTest{
+		{0, math.Inf(-1)},
+		{0.05, math.Inf(-1)},
+		{0.5, d.quantile(0.55)},
+		{0.8, d.quantile(0.94)},
+		{0.9, math.Inf(+1)},
+		{1, math.Inf(+1)},
+	} {
+		if have := d.Quantile(tc.q); have != tc.want {
+			t.Errorf("TDigest.Quantile(%v) wrong, have=%v, want=%v", tc.q, have, tc.want)
+		}
+	}
+
+	type cdfTest struct {
+		x    float64
+		want float64
+	}
+	for _, tc := range []cdfTest{
+		{math.Inf(-1), 10.0 / 130},
+		{-1, 10.0 / 130},
+		{1000, 110.0 / 130},
+		{math.Inf(+1), 1},
+	} {
+		if have := d.CDF(tc.x); have != tc.want {
+			t.Errorf("TDigest.CDF(%v) wrong, have=%v, want=%v", tc.x, have, tc.want)
+		}
+	}
+
+	other := New()
+	d.MergeInto(other)
+	if other.Count() != 130 || other.NaNCount() != 3 || !math.IsInf(other.Quantile(1), +1) {
+		t.Errorf("merge lost non-finite values, count=%d nanCount=%d", other.Count(), other.NaNCount())
+	}
+	if err := other.Subtract(d); err != nil {
+		t.Fatalf("Subtract err: %v", err)
+	}
+	if other.Count() != 0 || other.NaNCount() != 0 {
+		t.Errorf("Subtract left values, count=%d nanCount=%d", other.Count(), other.NaNCount())
+	}
+
+	d.Reset()
+	if d.Count() != 0 || d.NaNCount() != 0 || !math.IsNaN(d.Quantile(0.5)) {
+		t.Errorf("Reset left values, count=%d nanCount=%d", d.Count(), d.NaNCount())
+	}
+}
+
+func TestNonFiniteOnly(t *testing.T) {
+	d := New(WithNonFinite())
+	d.Add(math.Inf(+1), 1)
+	if have := d.Quantile(0); !math.IsInf(have, +1) {
+		t.Errorf("Quantile(0) of only +Inf wrong, have=%v", have)
+	}
+	if have := d.Min(); !math.IsInf(have, +1) {
+		t.Errorf("Min of only +Inf wrong, have=%v", have)
+	}
+
+	d = New(WithNonFinite())
+	d.Add(math.Inf(-1), 1)
+	if have := d.Quantile(1); !math.IsInf(have, -1) {
+		t.Errorf("Quantile(1) of only -Inf wrong, have=%v", have)
+	}
+
+	d = New()
+	d.Add(math.Inf(+1), 1)
+	d.Add(math.NaN(), 1)
+	if d.Count() != 0 || d.NaNCount() != 0 {
+		t.Errorf("non-finite values counted without WithNonFinite, count=%d nanCount=%d", d.Count(), d.NaNCount())
+	}
+}
+
+func TestTryAdd(t *testing.T) {
+	type testcase struct {
+		val     float64
+		weight  int
+		wantErr error
+	}
+	testcases := []testcase{
+		{1, 1, nil},
+		{2, 5, nil},
+		{math.NaN(), 1, ErrNaN},
+		{math.Inf(+1), 1, ErrInf},
+		{math.Inf(-1), 2, ErrInf},
+		{3, 0, ErrWeight},
+		{3, -4, ErrWeight},
+		{math.NaN(), -1, ErrWeight},
+	}
+
+	d := New()
+	for _, tc := range testcases {
+		err := d.TryAdd(tc.val, tc.weight)
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("TryAdd(%v, %d) wrong error, have=%v, want=%v", tc.val, tc.weight, err, tc.wantErr)
+		}
+		if tc.wantErr == nil {
+			continue
+		}
+		var inputErr *InputError
+		if !errors.As(err, &inputErr) {
+			t.Errorf("TryAdd(%v, %d) error has wrong type %T", tc.val, tc.weight, err)
+		} else if inputErr.Weight != tc.weight {
+			t.Errorf("InputError has wrong weight, have=%d, want=%d", inputErr.Weight, tc.weight)
+		}
+	}
+	if have := d.Count(); have != 6 {
+		t.Errorf("rejected inputs were added, count=%d, want=%d", have, 6)
+	}
+	want := Rejected{NaN: 1, Inf: 2, Weight: 3}
+	if have := d.Rejected(); have != want {
+		t.Errorf("TDigest.Rejected wrong, have=%+v, want=%+v", have, want)
+	}
+
+	// Add rejects the same inputs.
+	d.Add(4, -1)
+	d.Add(math.NaN(), 1)
+	want = Rejected{NaN: 2, Inf: 2, Weight: 4}
+	if have := d.Rejected(); have != want {
+		t.Errorf("TDigest.Rejected wrong after Add, have=%+v, want=%+v", have, want)
+	}
+	if have := d.Count(); have != 6 {
+		t.Errorf("Add added rejected inputs, count=%d, want=%d", have, 6)
+	}
+
+	d.Reset()
+	if have := d.Rejected(); have != (Rejected{}) {
+		t.Errorf("Reset left rejected counts %+v", have)
+	}
+
+	// With WithNonFinite, only weights are rejected.
+	d = New(WithNonFinite())
+	if err := d.TryAdd(math.NaN(), 1); err != nil {
+		t.Errorf("TryAdd(NaN) with WithNonFinite err: %v", err)
+	}
+	if err := d.TryAdd(math.Inf(+1), 0); !errors.Is(err, ErrWeight) {
+		t.Errorf("TryAdd(+Inf, 0) with WithNonFinite wrong error, have=%v", err)
+	}
+}